@@ -0,0 +1,248 @@
+// Package admission bounds the database work running at once across the server. Work waits in a
+// queue ordered by deadline, so live and narrow queries overtake wide historical ones without
+// starving them.
+package admission
+
+import (
+	"context"
+	"maps"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// slackPerSpan is the fraction of the queried span a query may be overtaken for
+	slackPerSpan = 2000
+	// maxSlack bounds the slack of the widest spans
+	maxSlack = time.Minute
+	// backgroundSlack is added to speculative work nobody waits for
+	backgroundSlack = 2 * time.Minute
+)
+
+// Controller is a weighted semaphore handing capacity to waiting work by earliest deadline
+type Controller struct {
+	capacity int64
+
+	lock    sync.Mutex
+	used    int64
+	queue   []*waiter
+	running int
+}
+
+type waiter struct {
+	weight   int64
+	deadline time.Time
+	ready    chan struct{}
+	// positions carries the latest queue position, replaced rather than queued
+	positions chan int
+	position  int
+}
+
+// Stats describes the state of a controller
+type Stats struct {
+	Capacity int64
+	Used     int64
+	Running  int
+	Queued   int
+}
+
+type observerKey struct{}
+
+type backgroundKey struct{}
+
+// New creates a controller admitting work up to capacity total weight
+func New(capacity int64) *Controller {
+	return &Controller{capacity: max(capacity, 1)}
+}
+
+// WithObserver returns a context whose work reports its queue position to f while it waits.
+// f is called from the waiting goroutine.
+func WithObserver(ctx context.Context, f func(position int)) context.Context {
+	return context.WithValue(ctx, observerKey{}, f)
+}
+
+// Relay fans the queue position of work shared by several callers out to the observers of all of
+// them, the shared work would otherwise report it to the caller that started it only
+type Relay struct {
+	lock      sync.Mutex
+	observers map[string]map[*func(int)]struct{}
+}
+
+// Join registers the observer of ctx for the work of key until the returned function is called
+func (r *Relay) Join(ctx context.Context, key string) func() {
+	observe, _ := ctx.Value(observerKey{}).(func(int))
+	if observe == nil {
+		return func() {}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.observers == nil {
+		r.observers = make(map[string]map[*func(int)]struct{})
+	}
+	if r.observers[key] == nil {
+		r.observers[key] = make(map[*func(int)]struct{})
+	}
+	r.observers[key][&observe] = struct{}{}
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		delete(r.observers[key], &observe)
+		if len(r.observers[key]) == 0 {
+			delete(r.observers, key)
+		}
+	}
+}
+
+// Context returns a context whose work reports its queue position to the observers joined for
+// key, in place of the observer of ctx
+func (r *Relay) Context(ctx context.Context, key string) context.Context {
+	return WithObserver(ctx, func(position int) {
+		r.lock.Lock()
+		observers := slices.Collect(maps.Keys(r.observers[key]))
+		r.lock.Unlock()
+		for _, observe := range observers {
+			(*observe)(position)
+		}
+	})
+}
+
+// Background returns a context marking its work as speculative, it yields to work clients wait for
+func Background(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// Weight returns the weight of querying span, growing with its logarithm from 1 for an hour or less
+func Weight(span time.Duration) int64 {
+	hours := uint64(max(span/time.Hour, 1)) //nolint:gosec // positive
+	return int64(bits.Len64(hours))
+}
+
+// Slack returns how long a query may be overtaken: not at all for the live tail, longer for wider spans
+func Slack(span time.Duration, live bool) time.Duration {
+	if live {
+		return 0
+	}
+	return min(span/slackPerSpan, maxSlack)
+}
+
+// Acquire waits until weight fits in the capacity and the work is first in line, it returns
+// the function releasing the capacity. Weights beyond the capacity take the whole capacity.
+func (c *Controller) Acquire(ctx context.Context, weight int64, slack time.Duration) (func(), error) {
+	if background, _ := ctx.Value(backgroundKey{}).(bool); background {
+		slack += backgroundSlack
+	}
+	w := &waiter{
+		weight:    min(max(weight, 1), c.capacity),
+		deadline:  time.Now().Add(slack),
+		ready:     make(chan struct{}),
+		positions: make(chan int, 1),
+	}
+
+	c.lock.Lock()
+	if len(c.queue) == 0 && c.used+w.weight <= c.capacity {
+		c.used += w.weight
+		c.running++
+		c.lock.Unlock()
+		return c.releaser(w), nil
+	}
+	c.insert(w)
+	c.lock.Unlock()
+
+	observe, _ := ctx.Value(observerKey{}).(func(int))
+	for {
+		select {
+		case <-w.ready:
+			return c.releaser(w), nil
+		case position := <-w.positions:
+			if observe != nil {
+				observe(position)
+			}
+		case <-ctx.Done():
+			c.lock.Lock()
+			select {
+			case <-w.ready:
+				// Admitted meanwhile, hand the capacity back
+				c.lock.Unlock()
+				c.releaser(w)()
+			default:
+				c.remove(w)
+				c.dispatch()
+				c.lock.Unlock()
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats returns the current state
+func (c *Controller) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return Stats{Capacity: c.capacity, Used: c.used, Running: c.running, Queued: len(c.queue)}
+}
+
+func (c *Controller) releaser(w *waiter) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.used -= w.weight
+			c.running--
+			c.dispatch()
+		})
+	}
+}
+
+// insert queues w by deadline, then by arrival
+func (c *Controller) insert(w *waiter) {
+	i, _ := slices.BinarySearchFunc(c.queue, w, func(queued *waiter, w *waiter) int {
+		if !queued.deadline.After(w.deadline) {
+			return -1
+		}
+		return 1
+	})
+	c.queue = slices.Insert(c.queue, i, w)
+	c.notifyPositions(i)
+}
+
+func (c *Controller) remove(w *waiter) {
+	if i := slices.Index(c.queue, w); i >= 0 {
+		c.queue = slices.Delete(c.queue, i, i+1)
+		c.notifyPositions(i)
+	}
+}
+
+// dispatch admits the head of the queue while it fits
+func (c *Controller) dispatch() {
+	admitted := 0
+	for admitted < len(c.queue) && c.used+c.queue[admitted].weight <= c.capacity {
+		w := c.queue[admitted]
+		c.used += w.weight
+		c.running++
+		close(w.ready)
+		admitted++
+	}
+	if admitted > 0 {
+		c.queue = slices.Delete(c.queue, 0, admitted)
+		c.notifyPositions(0)
+	}
+}
+
+// notifyPositions tells the waiters from index on about their position, counted from 1
+func (c *Controller) notifyPositions(from int) {
+	for i := from; i < len(c.queue); i++ {
+		w := c.queue[i]
+		if w.position == i+1 {
+			continue
+		}
+		w.position = i + 1
+		select {
+		case <-w.positions:
+		default:
+		}
+		w.positions <- w.position
+	}
+}
@@ -0,0 +1,157 @@
+package admission_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/admission"
+)
+
+func TestWeightAndSlack(t *testing.T) {
+	assert.Equal(t, int64(1), admission.Weight(time.Minute))
+	assert.Equal(t, int64(1), admission.Weight(time.Hour))
+	assert.Equal(t, int64(5), admission.Weight(24*time.Hour))
+	assert.Equal(t, int64(14), admission.Weight(365*24*time.Hour))
+
+	assert.Zero(t, admission.Slack(365*24*time.Hour, true))
+	assert.Less(t, admission.Slack(time.Hour, false), admission.Slack(24*time.Hour, false))
+	assert.Equal(t, time.Minute, admission.Slack(365*24*time.Hour, false))
+}
+
+// waitQueued waits until n acquisitions are queued
+func waitQueued(t *testing.T, c *admission.Controller, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return c.Stats().Queued == n }, time.Second, time.Millisecond)
+}
+
+func TestEarliestDeadlineFirst(t *testing.T) {
+	c := admission.New(2)
+	ctx := context.Background()
+	release, err := c.Acquire(ctx, 2, 0)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	start := func(name string, weight int64, slack time.Duration, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := c.Acquire(ctx, weight, slack)
+			if !assert.NoError(t, err) {
+				return
+			}
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			done()
+		}()
+		waitQueued(t, c, queued)
+	}
+	start("historical", 2, time.Minute, 1)
+	start("narrow", 2, time.Second, 2)
+	start("live", 1, 0, 3)
+
+	release()
+	wg.Wait()
+	assert.Equal(t, []string{"live", "narrow", "historical"}, order)
+	assert.Equal(t, admission.Stats{Capacity: 2}, c.Stats())
+}
+
+func TestQueuePositionsAndCancel(t *testing.T) {
+	c := admission.New(1)
+	release, err := c.Acquire(context.Background(), 1, 0)
+	require.NoError(t, err)
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	go func() {
+		_, _ = c.Acquire(firstCtx, 1, 0)
+	}()
+	waitQueued(t, c, 1)
+
+	positions := make(chan int, 10)
+	secondCtx := admission.WithObserver(context.Background(), func(position int) { positions <- position })
+	acquired := make(chan func())
+	go func() {
+		done, err := c.Acquire(secondCtx, 1, 0)
+		assert.NoError(t, err)
+		acquired <- done
+	}()
+	assert.Equal(t, 2, <-positions)
+
+	cancelFirst()
+	assert.Equal(t, 1, <-positions)
+	release()
+	done := <-acquired
+	done()
+	assert.Equal(t, admission.Stats{Capacity: 1}, c.Stats())
+}
+
+func TestBackgroundYields(t *testing.T) {
+	c := admission.New(1)
+	release, err := c.Acquire(context.Background(), 1, 0)
+	require.NoError(t, err)
+
+	order := make(chan string, 2)
+	go func() {
+		done, err := c.Acquire(admission.Background(context.Background()), 1, 0)
+		if assert.NoError(t, err) {
+			order <- "prefetch"
+			done()
+		}
+	}()
+	waitQueued(t, c, 1)
+	go func() {
+		done, err := c.Acquire(context.Background(), 1, time.Minute)
+		if assert.NoError(t, err) {
+			order <- "client"
+			done()
+		}
+	}()
+	waitQueued(t, c, 2)
+	release()
+	assert.Equal(t, "client", <-order)
+	assert.Equal(t, "prefetch", <-order)
+}
+
+func TestRelayReachesEveryCaller(t *testing.T) {
+	c := admission.New(1)
+	release, err := c.Acquire(context.Background(), 1, 0)
+	require.NoError(t, err)
+	aheadCtx, cancelAhead := context.WithCancel(context.Background())
+	go func() {
+		_, _ = c.Acquire(aheadCtx, 1, 0)
+	}()
+	waitQueued(t, c, 1)
+
+	var relay admission.Relay
+	first, second := make(chan int, 10), make(chan int, 10)
+	firstCtx := admission.WithObserver(context.Background(), func(position int) { first <- position })
+	secondCtx := admission.WithObserver(context.Background(), func(position int) { second <- position })
+	leaveFirst := relay.Join(firstCtx, "k")
+	leaveSecond := relay.Join(secondCtx, "k")
+	defer leaveSecond()
+
+	// The shared work runs with the context of the first caller
+	acquired := make(chan func())
+	go func() {
+		done, err := c.Acquire(relay.Context(firstCtx, "k"), 1, 0)
+		assert.NoError(t, err)
+		acquired <- done
+	}()
+	assert.Equal(t, 2, <-first)
+	assert.Equal(t, 2, <-second)
+
+	// Once gone the first caller hears no more
+	leaveFirst()
+	cancelAhead()
+	assert.Equal(t, 1, <-second)
+	release()
+	done := <-acquired
+	done()
+	assert.Empty(t, first)
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/admission"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/cache"
+	"github.com/vtpl1/cacheserver/coalesce"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/ratelimit"
+	"github.com/vtpl1/cacheserver/segments"
+	"github.com/vtpl1/cacheserver/store"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	minMergeGap   = 100  // finest merge gap in milliseconds
+	gapsPerSpan   = 5000 // a span is merged with a gap of span / gapsPerSpan
+	gapsPerBucket = 1000 // a bucket holds the documents starting within gapsPerBucket gaps
+	maxBatchSize  = 200  // segments per websocket message
+
+	defaultCacheEntries      = 20000
+	defaultAdmissionCapacity = 32
+	defaultSettleWindow      = time.Hour
+)
+
+var errInvalidBucketKey = errors.New("invalid bucket key")
+
+// Options configures the timeline query layer shared by the handlers
+type Options struct {
+	// CacheEntries bounds the number of cached buckets, 0 means unbounded
+	CacheEntries int
+	// PrefetchConcurrency bounds the concurrent speculative bucket computations, 0 disables prefetching
+	PrefetchConcurrency int
+	// Tiers are consulted in order behind the memory cache, such as a Redis backend shared by the
+	// replicas and a disk store keeping buckets across restarts
+	Tiers []cache.Tier
+	// Policy restricts the sites and channels each principal may read, nil allows everything
+	Policy *auth.Policy
+	// Limiter budgets the queries of each client, nil disables rate limiting
+	Limiter *ratelimit.Limiter
+	// AdmissionCapacity bounds the total weight of the aggregations running at once, 0 means unbounded
+	AdmissionCapacity int64
+	// Store reads the lanes, nil reads them from MongoDB
+	Store store.TimelineStore
+	// SettleWindow is how long before now documents may still arrive late or grow, the buckets
+	// ending within it are read from the store rather than cached. 0 means an hour, the live tail
+	// window is used when longer.
+	SettleWindow time.Duration
+}
+
+// DefaultOptions returns the options used when Configure is not called
+func DefaultOptions() Options {
+	return Options{
+		CacheEntries:        defaultCacheEntries,
+		PrefetchConcurrency: defaultPrefetchConcurrency,
+		AdmissionCapacity:   defaultAdmissionCapacity,
+		SettleWindow:        defaultSettleWindow,
+	}
+}
+
+var (
+	bucketCache   = cache.NewCache(loadBucket, defaultCacheEntries) //nolint:gochecknoglobals
+	prefetchSlots = make(chan struct{}, defaultPrefetchConcurrency) //nolint:gochecknoglobals
+	policy        *auth.Policy                                      //nolint:gochecknoglobals
+	limiter       *ratelimit.Limiter                                //nolint:gochecknoglobals
+	admitter      = admission.New(defaultAdmissionCapacity)         //nolint:gochecknoglobals
+	// inflight coalesces the aggregations running for several clients, results must not be modified
+	inflight coalesce.Group[[]models.Segment] //nolint:gochecknoglobals
+	// queuePositions reports the queue position of shared buckets and aggregations to every client
+	// waiting for them
+	queuePositions admission.Relay       //nolint:gochecknoglobals
+	settleWindow   = defaultSettleWindow //nolint:gochecknoglobals
+)
+
+// timelineStore reads the lanes
+var timelineStore store.TimelineStore = store.NewMongo(store.MongoOptions{}) //nolint:gochecknoglobals
+
+// Configure replaces the bucket cache, the prefetch budget, the policy, the limiter, the
+// admission controller, the store and the settle window, it must be called before serving
+func Configure(opts Options) {
+	bucketCache = cache.NewCache(cache.Layered(loadBucket, opts.Tiers...), opts.CacheEntries)
+	prefetchSlots = make(chan struct{}, opts.PrefetchConcurrency)
+	policy = opts.Policy
+	limiter = opts.Limiter
+	admitter = nil
+	if opts.AdmissionCapacity > 0 {
+		admitter = admission.New(opts.AdmissionCapacity)
+	}
+	timelineStore = opts.Store
+	if timelineStore == nil {
+		timelineStore = store.NewMongo(store.MongoOptions{})
+	}
+	settleWindow = opts.SettleWindow
+	if settleWindow <= 0 {
+		settleWindow = defaultSettleWindow
+	}
+}
+
+// settled reports whether the documents starting before end are no longer expected to change
+func settled(end int64) bool {
+	window := max(settleWindow, db.GetClientOptions().LiveTailWindow)
+	return end < time.Now().Add(-window).UnixMilli()
+}
+
+// mergeGap returns the merge gap of a span, span / gapsPerSpan but at least minMergeGap
+func mergeGap(span int64) int64 {
+	return max(span/gapsPerSpan, minMergeGap)
+}
+
+// pointsGap returns the smallest gap of at least minMergeGap splitting span into at most points
+// gaps, the segments merged with it are at most about points
+func pointsGap(span int64, points int64) int64 {
+	return max((span+points-1)/points, minMergeGap)
+}
+
+// bucket holds the segments merged with gap from the documents of a lane starting in
+// [start, start+width)
+type bucket struct {
+	lane      db.Lane
+	siteID    int
+	channelID int
+	gap       int64
+	width     int64
+	start     int64
+}
+
+func (b bucket) end() int64 {
+	return b.start + b.width
+}
+
+func (b bucket) key() string {
+	return fmt.Sprintf("%s/%d/%d/%d/%d/%d", b.lane.Name, b.siteID, b.channelID, b.gap, b.width, b.start)
+}
+
+func parseBucketKey(key string) (bucket, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 6 {
+		return bucket{}, errInvalidBucketKey
+	}
+	var b bucket
+	var ok bool
+	if b.lane, ok = db.LaneByName(parts[0]); !ok {
+		return bucket{}, errInvalidBucketKey
+	}
+	var err error
+	if b.siteID, err = strconv.Atoi(parts[1]); err != nil {
+		return bucket{}, errInvalidBucketKey
+	}
+	if b.channelID, err = strconv.Atoi(parts[2]); err != nil {
+		return bucket{}, errInvalidBucketKey
+	}
+	if b.gap, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+		return bucket{}, errInvalidBucketKey
+	}
+	if b.width, err = strconv.ParseInt(parts[4], 10, 64); err != nil || b.width <= 0 {
+		return bucket{}, errInvalidBucketKey
+	}
+	if b.start, err = strconv.ParseInt(parts[5], 10, 64); err != nil {
+		return bucket{}, errInvalidBucketKey
+	}
+	return b, nil
+}
+
+// bucketsOf returns the buckets holding the documents starting in [domainMin, domainMax]. A
+// bucket spans gapsPerBucket gaps, of the merge gap of the range when gap is finer, so that a
+// range has a few buckets whatever the gap.
+func bucketsOf(lane db.Lane, siteID int, channelID int, gap int64, domainMin int64, domainMax int64) []bucket {
+	width := max(gap, mergeGap(domainMax-domainMin)) * gapsPerBucket
+	var buckets []bucket
+	for start := domainMin - domainMin%width; start <= domainMax; start += width {
+		buckets = append(buckets, bucket{lane, siteID, channelID, gap, width, start})
+	}
+	return buckets
+}
+
+// laneSegments returns the segments of a lane overlapping [domainMin, domainMax] merged with gap.
+// The documents starting in the range come from cached buckets, the few starting earlier but
+// still overlapping are queried directly.
+func laneSegments(ctx context.Context, lane db.Lane, siteID int, channelID int, domainMin int64, domainMax int64, gap int64) ([]models.Segment, error) {
+	buckets := bucketsOf(lane, siteID, channelID, gap, domainMin, domainMax)
+	results := make([][]models.Segment, len(buckets)+1)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		results[0], err = querySegments(gctx, store.Query{
+			Lane: lane, SiteID: siteID, ChannelID: channelID,
+			StartMax: buckets[0].start, EndMin: domainMin, Reach: domainMax,
+		}, gap, domainMin, domainMax)
+		return err
+	})
+	for i, b := range buckets {
+		g.Go(func() error {
+			var err error
+			results[i+1], err = bucketSegments(gctx, b)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return segments.Overlapping(segments.Stitch(slices.Concat(results...), gap), uint64(domainMin), uint64(domainMax)), nil
+}
+
+// bucketSegments returns the segments of a bucket, from the cache once the bucket has settled
+func bucketSegments(ctx context.Context, b bucket) ([]models.Segment, error) {
+	if !settled(b.end()) {
+		return b.query(ctx)
+	}
+	defer queuePositions.Join(ctx, b.key())()
+	data, err := bucketCache.Get(ctx, b.key())
+	if err != nil {
+		return nil, err
+	}
+	var segs []models.Segment
+	err = json.Unmarshal(data, &segs)
+	return segs, err
+}
+
+func loadBucket(ctx context.Context, key string) ([]byte, error) {
+	b, err := parseBucketKey(key)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := b.query(queuePositions.Context(ctx, key))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(segs)
+}
+
+func (b bucket) query(ctx context.Context) ([]models.Segment, error) {
+	return querySegments(ctx, store.Query{
+		Lane: b.lane, SiteID: b.siteID, ChannelID: b.channelID,
+		StartMin: b.start, StartMax: b.end(),
+	}, b.gap, b.start, b.end())
+}
+
+// querySegments returns the documents of q merged with gap from the store. [start, end] is the
+// queried span, it weighs the query for admission.
+func querySegments(ctx context.Context, q store.Query, gap int64, start int64, end int64) ([]models.Segment, error) {
+	// The aggregation may outlive its callers, it keeps the store and controller it started with
+	timelines, controller := timelineStore, admitter
+	// Identical queries of different clients share one aggregation
+	key := fmt.Sprintf("%+v/%d/%t", q, gap, db.GetClientOptions().IsLiveTail(end))
+	defer queuePositions.Join(ctx, key)()
+	segs, shared, err := inflight.Do(ctx, key, func(queryCtx context.Context) ([]models.Segment, error) {
+		release, admitErr := admitTo(queuePositions.Context(queryCtx, key), controller, start, end)
+		if admitErr != nil {
+			return nil, admitErr
+		}
+		defer release()
+		return timelines.Segments(queryCtx, q, gap)
+	})
+	if shared {
+		log.Debug().Str("query", key).Msg("Shared aggregation")
+	}
+	return segs, err
+}
+
+// admit waits until the admission controller lets a query of [start, end] run and returns the
+// function to call once it is done
+func admit(ctx context.Context, start int64, end int64) (func(), error) {
+	return admitTo(ctx, admitter, start, end)
+}
+
+// admitTo waits until controller lets a query of [start, end] run, a nil controller admits at once
+func admitTo(ctx context.Context, controller *admission.Controller, start int64, end int64) (func(), error) {
+	if controller == nil {
+		return func() {}, nil
+	}
+	span := time.Duration(end-start) * time.Millisecond
+	return controller.Acquire(ctx, admission.Weight(span), admission.Slack(span, db.GetClientOptions().IsLiveTail(end)))
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vtpl1/cacheserver/db"
+)
+
+func TestBucketsOfFineGap(t *testing.T) {
+	humans, _ := db.LaneByName(db.LaneHumans)
+	year := (365 * 24 * time.Hour).Milliseconds()
+	buckets := bucketsOf(humans, 1, 1, minMergeGap, 1704067200000, 1704067200000+year)
+	if len(buckets) > gapsPerSpan/gapsPerBucket+2 {
+		t.Fatalf("expected a few buckets for a year merged with %d ms, got %d", minMergeGap, len(buckets))
+	}
+	for _, b := range buckets {
+		if b.gap != minMergeGap {
+			t.Fatalf("expected the buckets to merge with %d ms, got %d", minMergeGap, b.gap)
+		}
+	}
+	parsed, err := parseBucketKey(buckets[0].key())
+	if err != nil || parsed != buckets[0] {
+		t.Fatalf("expected %+v from its key, got %+v, %v", buckets[0], parsed, err)
+	}
+}
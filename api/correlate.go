@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/segments"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxExpressionNodes bounds the size of a correlate expression
+const maxExpressionNodes = 32
+
+// correlationLane names the segments answering a correlate command
+const correlationLane = "correlation"
+
+var errInvalidExpression = errors.New("invalid expression")
+
+// expressionLanes validates an expression and returns the lanes it reads
+func expressionLanes(expr *models.Expression) ([]db.Lane, error) {
+	if expr == nil {
+		return nil, fmt.Errorf("%w: missing", errInvalidExpression)
+	}
+	var lanes []db.Lane
+	seen := make(map[string]bool)
+	nodes := 0
+	var walk func(e *models.Expression) error
+	walk = func(e *models.Expression) error {
+		nodes++
+		if nodes > maxExpressionNodes {
+			return fmt.Errorf("%w: more than %d nodes", errInvalidExpression, maxExpressionNodes)
+		}
+		if e.Lane != "" {
+			if e.Op != "" || len(e.Args) > 0 {
+				return fmt.Errorf("%w: lane %q with an op or args", errInvalidExpression, e.Lane)
+			}
+			lane, ok := db.LaneByName(e.Lane)
+			if !ok {
+				return fmt.Errorf("%w: unknown lane %q", errInvalidExpression, e.Lane)
+			}
+			if !seen[lane.Name] {
+				seen[lane.Name] = true
+				lanes = append(lanes, lane)
+			}
+			return nil
+		}
+		switch e.Op {
+		case models.OpAnd, models.OpOr:
+			if len(e.Args) == 0 {
+				return fmt.Errorf("%w: %s without args", errInvalidExpression, e.Op)
+			}
+		case models.OpNot:
+			if len(e.Args) != 1 {
+				return fmt.Errorf("%w: not takes one arg", errInvalidExpression)
+			}
+		case models.OpTouching:
+			if len(e.Args) != 2 {
+				return fmt.Errorf("%w: touching takes two args", errInvalidExpression)
+			}
+		default:
+			return fmt.Errorf("%w: unknown op %q", errInvalidExpression, e.Op)
+		}
+		for i := range e.Args {
+			if err := walk(&e.Args[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(expr); err != nil {
+		return nil, err
+	}
+	return lanes, nil
+}
+
+// evaluate applies an expression validated by expressionLanes to the segments of its lanes
+func evaluate(expr *models.Expression, laneSegs map[string][]models.Segment, start uint64, end uint64) []models.Segment {
+	if expr.Lane != "" {
+		return laneSegs[expr.Lane]
+	}
+	args := make([][]models.Segment, len(expr.Args))
+	for i := range expr.Args {
+		args[i] = evaluate(&expr.Args[i], laneSegs, start, end)
+	}
+	switch expr.Op {
+	case models.OpAnd:
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = segments.Intersect(result, arg)
+		}
+		return result
+	case models.OpOr:
+		return segments.Union(args...)
+	case models.OpNot:
+		return segments.Complement(args[0], start, end)
+	case models.OpTouching:
+		return segments.Touching(args[0], args[1])
+	}
+	return nil
+}
+
+// correlate returns the segments of an expression over [domainMin, domainMax], combining the
+// lanes merged with gap as they are served in segments mode
+func correlate(ctx context.Context, expr *models.Expression, lanes []db.Lane, siteID int, channelID int, domainMin int64, domainMax int64, gap int64) ([]models.Segment, error) {
+	results := make([][]models.Segment, len(lanes))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, lane := range lanes {
+		g.Go(func() error {
+			segs, err := laneSegments(gctx, lane, siteID, channelID, domainMin, domainMax, gap)
+			if err != nil {
+				return fmt.Errorf("%s: %w", lane.Name, err)
+			}
+			results[i] = segs
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	laneSegs := make(map[string][]models.Segment, len(lanes))
+	for i, lane := range lanes {
+		laneSegs[lane.Name] = results[i]
+	}
+	return evaluate(expr, laneSegs, uint64(domainMin), uint64(domainMax)), nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/segments"
+)
+
+const (
+	// defaultMinGap is the shortest gap in milliseconds listed when a request names none
+	defaultMinGap = 60 * 1000
+	// coverageTimeout bounds the computation of a coverage report
+	coverageTimeout = 5 * time.Second
+)
+
+var (
+	errInvalidMinGap    = errors.New("invalid minGap, expected a positive number of milliseconds")
+	errNoRecordingsLane = errors.New("recordings lane is not configured")
+)
+
+// coverageReport reports the recordings of a site and channel over [domainMin, domainMax] within
+// coverageTimeout. The recordings are merged with the merge gap of the span unless minGap is
+// shorter, so that every gap of at least minGap is listed, shorter gaps may count as recorded.
+// The buckets follow the merge gap of the span whatever minGap, so their number stays bounded.
+func coverageReport(ctx context.Context, siteID int, channelID int, domainMin int64, domainMax int64, minGap int64) (models.CoverageReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, coverageTimeout)
+	defer cancel()
+	lane, ok := db.LaneByName(db.LaneRecordings)
+	if !ok {
+		return models.CoverageReport{}, errNoRecordingsLane
+	}
+	gap := max(min(minGap, mergeGap(domainMax-domainMin)), minMergeGap)
+	segs, err := laneSegments(ctx, lane, siteID, channelID, domainMin, domainMax, gap)
+	if err != nil {
+		return models.CoverageReport{}, err
+	}
+	recorded, gaps := segments.Coverage(segs, uint64(domainMin), uint64(domainMax), minGap)
+	report := models.CoverageReport{
+		SiteID:           siteID,
+		ChannelID:        channelID,
+		TimeStamp:        uint64(domainMin),
+		TimeStampEnd:     uint64(domainMax),
+		RecordedDuration: recorded,
+		Gaps:             gaps,
+		MinGap:           minGap,
+		Resolution:       gap,
+	}
+	if report.Gaps == nil {
+		report.Gaps = []models.Gap{}
+	}
+	if domainMax > domainMin {
+		report.Coverage = 100 * float64(recorded) / float64(domainMax-domainMin)
+	}
+	return report, nil
+}
+
+// CoverageHandler reports the recording coverage and the gaps of at least ?minGap milliseconds
+// of a site and channel
+func CoverageHandler(c *fiber.Ctx) error {
+	siteID, channelID, timeStamp, timeStampEnd, err := parseParams(c)
+	if err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	logger := log.With().
+		Int("siteId", siteID).
+		Int("channelId", channelID).
+		Uint64("timeStamp", timeStamp).
+		Uint64("timeStampEnd", timeStampEnd).
+		Logger()
+	if timeStampEnd < timeStamp {
+		logger.Error().Msg("Invalid time range")
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid time range")
+	}
+	minGap := int64(c.QueryInt("minGap", defaultMinGap))
+	if minGap <= 0 {
+		return c.Status(fiber.StatusBadRequest).SendString(errInvalidMinGap.Error())
+	}
+	principal, authenticated := auth.FromCtx(c)
+	if err = acquireBudget(c.Context(), clientKey(principal, authenticated, c.IP()), int64(timeStampEnd-timeStamp), 1); err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+
+	report, err := coverageReport(c.Context(), siteID, channelID, int64(timeStamp), int64(timeStampEnd), minGap)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error fetching recordings")
+		return c.Status(fiber.StatusInternalServerError).SendString("Error fetching recordings")
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		logger.Error().Err(err).Msg("Error marshaling JSON")
+		return c.Status(fiber.StatusInternalServerError).SendString("Error marshaling JSON")
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(data)
+}
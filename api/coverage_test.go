@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/store"
+)
+
+func TestCoverageHandler(t *testing.T) {
+	m := store.NewMemory()
+	m.Add(db.LaneRecordings, 5, 5,
+		models.Segment{TimeStamp: 1733000000000, TimeStampEnd: 1733003600000},
+		models.Segment{TimeStamp: 1733003900000, TimeStampEnd: 1733007200000},
+	)
+	configure(t, m)
+	app := fiber.New()
+	app.Get("site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/coverage", api.CoverageHandler)
+
+	// The five minutes between the recordings are longer than minGap but shorter than the merge
+	// gap of thirty days
+	resp, err := app.Test(httptest.NewRequest("GET", "/site/5/channel/5/1733000000000/1735592000000/coverage?minGap=60000", nil), 2000)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var report models.CoverageReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+
+	assert.Equal(t, int64(60000), report.Resolution)
+	assert.Equal(t, int64(6900000), report.RecordedDuration)
+	assert.InDelta(t, 100*6900000/2592000000.0, report.Coverage, 1e-9)
+	assert.Equal(t, []models.Gap{
+		{TimeStamp: 1733003600000, TimeStampEnd: 1733003900000, Duration: 300000},
+		{TimeStamp: 1733007200000, TimeStampEnd: 1735592000000, Duration: 2584800000},
+	}, report.Gaps)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/site/5/channel/5/1733000000000/1735592000000/coverage?minGap=0", nil), 2000)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+}
+
+// countingStore counts the segment queries
+type countingStore struct {
+	store.TimelineStore
+	queries atomic.Int64
+}
+
+func (s *countingStore) Segments(ctx context.Context, q store.Query, gap int64) ([]models.Segment, error) {
+	s.queries.Add(1)
+	return s.TimelineStore.Segments(ctx, q, gap)
+}
+
+func TestCoverageHandlerFineMinGap(t *testing.T) {
+	counting := &countingStore{TimelineStore: store.NewMemory()}
+	configure(t, counting)
+	app := fiber.New()
+	app.Get("site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/coverage", api.CoverageHandler)
+
+	// A year listing the gaps of 100 ms is read in a few buckets
+	resp, err := app.Test(httptest.NewRequest("GET", "/site/5/channel/5/1704067200000/1735689600000/coverage?minGap=100", nil), 2000)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.LessOrEqual(t, counting.queries.Load(), int64(10))
+}
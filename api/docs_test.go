@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/docs"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+// TestResponsesMatchOpenAPI fails when a handler answers with a status, content type or body the
+// OpenAPI document does not describe
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	configure(t, pageStore())
+	app := fiber.New()
+	routes := map[string]fiber.Handler{
+		"/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all": timeLineHandler(context.Background()),
+		"/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/coverage":     api.CoverageHandler,
+		"/site/:siteId/channel/:channelId/stats":                                 api.LaneStatsHandler,
+		"/site/:siteId/channel/:channelId/snap/:timeStamp":                       api.SnapHandler,
+		"/api/v1/timeline/site/:siteId/channel/:channelId":                       api.TimelineV1Handler,
+		"/api/v1/export/site/:siteId/channel/:channelId":                         exportHandler,
+	}
+	for route, handler := range routes {
+		app.Get(route, handler)
+	}
+
+	for _, tc := range []struct {
+		route  string
+		url    string
+		accept string
+		status int
+	}{
+		{"/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all", "/site/5/channel/5/1733930000000/1733933600000/timeline/all", "", 200},
+		{"/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all", "/site/5/channel/5/1733930000000/1733933600000/timeline/all", "application/x-ndjson", 200},
+		{"/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all", "/site/5/channel/5/2/1/timeline/all", "", 400},
+		{"/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/coverage", "/site/5/channel/5/1733930000000/1733933600000/coverage", "", 200},
+		{"/site/:siteId/channel/:channelId/stats", "/site/5/channel/5/stats", "", 200},
+		{"/site/:siteId/channel/:channelId/snap/:timeStamp", "/site/5/channel/5/snap/1733931800000?lane=humans", "", 200},
+		{"/site/:siteId/channel/:channelId/snap/:timeStamp", "/site/5/channel/5/snap/1733931800000", "", 404},
+		{"/api/v1/timeline/site/:siteId/channel/:channelId", "/api/v1/timeline/site/5/channel/5?timeStamp=1733930000000&timeStampEnd=1733933600000&limit=2", "", 200},
+		{"/api/v1/timeline/site/:siteId/channel/:channelId", "/api/v1/timeline/site/5/channel/5?timeStamp=1733930000000&timeStampEnd=1733933600000&lanes=faces", "", 400},
+		{"/api/v1/export/site/:siteId/channel/:channelId", "/api/v1/export/site/5/channel/5?timeStamp=1733930000000&timeStampEnd=1733933600000", "", 200},
+		{"/api/v1/export/site/:siteId/channel/:channelId", "/api/v1/export/site/5/channel/5?timeStamp=1733930000000&timeStampEnd=1733933600000&format=xlsx", "", 200},
+	} {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		if tc.accept != "" {
+			req.Header.Set(fiber.HeaderAccept, tc.accept)
+		}
+		resp, err := app.Test(req, 2000)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, tc.status, resp.StatusCode, tc.url)
+		assert.NoError(t, docs.ValidateResponse(tc.route, resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), body), tc.url)
+	}
+}
+
+// TestMessagesMatchAsyncAPI fails when the websocket sends a message the AsyncAPI document does not
+// describe
+func TestMessagesMatchAsyncAPI(t *testing.T) {
+	configure(t, pageStore())
+	conn := dialTimeline(t, startWSServer(t), "/ws/timeline/site/5/channel/5")
+
+	expression := &models.Expression{Op: models.OpOr, Args: []models.Expression{{Lane: "humans"}, {Lane: "vehicles"}}}
+	for _, tc := range []struct {
+		command models.Command
+		// last is the type and status of the last message of the command
+		last, status string
+	}{
+		{models.Command{CommandID: "segments", DomainMin: 1733930000000, DomainMax: 1733933600000}, "status", "done"},
+		{models.Command{CommandID: "density", DomainMin: 1733930000000, DomainMax: 1733933600000, Mode: models.ModeDensity, Bins: 10}, "status", "done"},
+		{models.Command{CommandID: "coverage", DomainMin: 1733930000000, DomainMax: 1733933600000, Mode: models.ModeCoverage}, "coverage", ""},
+		{models.Command{CommandID: "correlate", DomainMin: 1733930000000, DomainMax: 1733933600000, Mode: models.ModeCorrelate, Expression: expression}, "correlation", "done"},
+		{models.Command{CommandID: "invalid", DomainMin: 2, DomainMax: 1}, "error", ""},
+	} {
+		require.NoError(t, conn.WriteJSON(tc.command))
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		for {
+			_, data, err := conn.ReadMessage()
+			require.NoError(t, err)
+			require.NoError(t, docs.ValidateMessage(data), string(data))
+			var f frame
+			require.NoError(t, json.Unmarshal(data, &f))
+			if f.kind() == tc.last && f.status() == tc.status {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/export"
+)
+
+var (
+	errInvalidTimeZone = errors.New("invalid tz, expected an IANA time zone such as Asia/Kolkata")
+	errExport          = errors.New("error exporting")
+)
+
+// exportRequest is a query of the merged segments of several lanes written as a spreadsheet
+type exportRequest struct {
+	siteID       int
+	channelID    int
+	timeStamp    uint64
+	timeStampEnd uint64
+	lanes        []db.Lane
+	gap          int64
+	format       string
+	loc          *time.Location
+}
+
+// parseExportRequest parses an export request from the path parameters siteId and channelId and
+// the query parameters timeStamp, timeStampEnd, lanes, gap, maxPoints, format and tz
+func parseExportRequest(c *fiber.Ctx) (exportRequest, error) {
+	siteID, channelID, err := parseParamsSiteIDChannelID(c)
+	if err != nil {
+		return exportRequest{}, err
+	}
+	req := exportRequest{siteID: siteID, channelID: channelID, format: c.Query("format", export.FormatCSV)}
+	if req.format != export.FormatCSV && req.format != export.FormatXLSX {
+		return exportRequest{}, export.ErrUnknownFormat
+	}
+	if req.timeStamp, err = strconv.ParseUint(c.Query("timeStamp"), 10, 64); err != nil {
+		return exportRequest{}, errInvalidTimeStamp
+	}
+	if req.timeStampEnd, err = strconv.ParseUint(c.Query("timeStampEnd"), 10, 64); err != nil {
+		return exportRequest{}, errInvalidTimeStampEnd
+	}
+	if req.timeStampEnd < req.timeStamp {
+		return exportRequest{}, errInvalidTimeRange
+	}
+	if req.lanes, err = parseLanes(c.Query("lanes")); err != nil {
+		return exportRequest{}, err
+	}
+	if req.gap, err = parseGap(c, int64(req.timeStampEnd-req.timeStamp)); err != nil {
+		return exportRequest{}, err
+	}
+	if req.loc, err = time.LoadLocation(c.Query("tz", "UTC")); err != nil {
+		return exportRequest{}, errInvalidTimeZone
+	}
+	return req, nil
+}
+
+// ExportHandler downloads the merged segments of a site and channel over [?timeStamp,
+// ?timeStampEnd] as a spreadsheet, ?format csv (default) or xlsx, with times in the ?tz time
+// zone, UTC by default. ?lanes, ?gap and ?maxPoints select the segments like api/v1/timeline.
+// The rows are streamed lane after lane in start order as each lane is merged, a failure ends
+// the export with an error row. Exports end when ctx is done.
+func ExportHandler(ctx context.Context, c *fiber.Ctx) error {
+	req, err := parseExportRequest(c)
+	if err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	logger := log.With().
+		Int("siteId", req.siteID).
+		Int("channelId", req.channelID).
+		Uint64("timeStamp", req.timeStamp).
+		Uint64("timeStampEnd", req.timeStampEnd).
+		Int64("gap", req.gap).
+		Str("format", req.format).
+		Logger()
+	principal, authenticated := auth.FromCtx(c)
+	if err = acquireBudget(c.Context(), clientKey(principal, authenticated, c.IP()), int64(req.timeStampEnd-req.timeStamp), len(req.lanes)); err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+
+	c.Set(fiber.HeaderContentType, export.ContentType(req.format))
+	c.Attachment(fmt.Sprintf("timeline-%d-%d-%d.%s", req.siteID, req.channelID, req.timeStamp, req.format))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		exportCtx, cancel := context.WithTimeout(ctx, streamTimeout)
+		defer cancel()
+		out, newErr := export.New(w, req.format, req.loc)
+		if newErr != nil {
+			logger.Error().Err(newErr).Msg("Error exporting")
+			return
+		}
+		rows := 0
+		for _, lane := range req.lanes {
+			segs, laneErr := laneSegments(exportCtx, lane, req.siteID, req.channelID, int64(req.timeStamp), int64(req.timeStampEnd), req.gap)
+			for _, seg := range segs {
+				if laneErr = out.WriteSegment(lane.Name, seg); laneErr != nil {
+					break
+				}
+			}
+			if laneErr == nil {
+				laneErr = w.Flush()
+			}
+			if laneErr != nil {
+				logger.Error().Err(laneErr).Str("lane", lane.Name).Msg("Error exporting")
+				_ = out.WriteError(fmt.Errorf("%w %s", errExport, lane.Name))
+				break
+			}
+			rows += len(segs)
+		}
+		if closeErr := out.Close(); closeErr != nil {
+			logger.Error().Err(closeErr).Msg("Error exporting")
+			return
+		}
+		_ = w.Flush()
+		logger.Info().Int("rows", rows).Msg("Exported")
+	})
+	return nil
+}
@@ -0,0 +1,90 @@
+package api_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+)
+
+// exportHandler serves ExportHandler with exports that never end early
+func exportHandler(c *fiber.Ctx) error {
+	return api.ExportHandler(context.Background(), c)
+}
+
+func getExport(t *testing.T, app *fiber.App, params ...string) (int, string, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/export/site/5/channel/5?"+pageQuery(params...).Encode(), nil)
+	resp, err := app.Test(req, 2000)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), string(body)
+}
+
+func TestExportHandlerCSV(t *testing.T) {
+	configure(t, pageStore())
+	app := fiber.New()
+	app.Get("api/v1/export/site/:siteId/channel/:channelId", exportHandler)
+
+	status, contentType, body := getExport(t, app, "lanes", "humans,vehicles", "gap", "100", "tz", "Asia/Kolkata")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "text/csv; charset=utf-8", contentType)
+	assert.Equal(t, `Lane,Start (Asia/Kolkata),End (Asia/Kolkata),Duration,Duration (s),Object count
+humans,2024-12-11 21:00:00.000,2024-12-11 21:01:00.000,00:01:00.000,60.000,1
+humans,2024-12-11 21:05:00.000,2024-12-11 21:06:00.000,00:01:00.000,60.000,2
+humans,2024-12-11 21:10:00.000,2024-12-11 21:11:00.000,00:01:00.000,60.000,3
+vehicles,2024-12-11 21:05:00.000,2024-12-11 21:05:30.000,00:00:30.000,30.000,4
+vehicles,2024-12-11 21:15:00.000,2024-12-11 21:15:30.000,00:00:30.000,30.000,5
+`, body)
+
+	status, _, body = getExport(t, app, "lanes", "vehicles", "gap", "600000")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, `Lane,Start (UTC),End (UTC),Duration,Duration (s),Object count
+vehicles,2024-12-11 15:35:00.000,2024-12-11 15:45:30.000,00:10:30.000,630.000,9
+`, body, "UTC by default and merged with the gap")
+}
+
+func TestExportHandlerXLSX(t *testing.T) {
+	configure(t, pageStore())
+	app := fiber.New()
+	app.Get("api/v1/export/site/:siteId/channel/:channelId", exportHandler)
+
+	status, contentType, body := getExport(t, app, "lanes", "humans", "format", "xlsx")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", contentType)
+	workbook, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	require.NoError(t, err)
+	sheet, err := workbook.Open("xl/worksheets/sheet1.xml")
+	require.NoError(t, err)
+	content, err := io.ReadAll(sheet)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "2024-12-11 15:30:00.000")
+}
+
+func TestExportHandlerInvalidParams(t *testing.T) {
+	configure(t, pageStore())
+	app := fiber.New()
+	app.Get("api/v1/export/site/:siteId/channel/:channelId", exportHandler)
+
+	for _, params := range [][]string{
+		{"format", "pdf"},
+		{"tz", "Mars/Olympus"},
+		{"lanes", "faces"},
+		{"gap", "10"},
+		{"gap", "abc"},
+		{"maxPoints", "-1"},
+		{"timeStampEnd", "1"},
+	} {
+		status, _, _ := getExport(t, app, params...)
+		assert.Equal(t, fiber.StatusBadRequest, status, params)
+	}
+}
@@ -0,0 +1,118 @@
+package api_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	fasthttp_websocket "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/store"
+)
+
+// fixtureStore returns a memory store holding the fixtures of testdatasuite
+func fixtureStore(t *testing.T) *store.Memory {
+	t.Helper()
+	m, err := store.LoadMemory(filepath.Join("..", "testdatasuite"))
+	require.NoError(t, err)
+	return m
+}
+
+// configure installs timelines as the store of the api package for the duration of the test,
+// without prefetching so that no query outlives it
+func configure(t *testing.T, timelines store.TimelineStore) {
+	t.Helper()
+	opts := api.DefaultOptions()
+	opts.Store = timelines
+	opts.PrefetchConcurrency = 0
+	api.Configure(opts)
+	t.Cleanup(func() { api.Configure(api.DefaultOptions()) })
+}
+
+// startWSServer serves the timeline websocket like main on a free local port and returns its
+// base url. The handlers get a context of the test rather than the recycled request. At the end
+// of the test, once the clients are closed, it waits for the handlers to return and shuts down.
+func startWSServer(t *testing.T) string {
+	t.Helper()
+	var lock sync.Mutex
+	var handlers sync.WaitGroup
+	stopped := false
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	app.Use("/ws", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+	app.Use("/ws/timeline/site/:siteId/channel/:channelId", websocket.New(func(c *websocket.Conn) {
+		lock.Lock()
+		if stopped {
+			lock.Unlock()
+			return
+		}
+		handlers.Add(1)
+		lock.Unlock()
+		defer handlers.Done()
+		api.TimeLineWSHandler(ctx, c)
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go app.Listener(listener) //nolint:errcheck
+	t.Cleanup(func() {
+		lock.Lock()
+		stopped = true
+		lock.Unlock()
+		handlers.Wait()
+		cancel()
+		_ = app.Shutdown()
+	})
+	return "ws://" + listener.Addr().String()
+}
+
+// dialTimeline opens the timeline websocket of a site and channel
+func dialTimeline(t *testing.T, baseURL string, path string) *fasthttp_websocket.Conn {
+	t.Helper()
+	conn, resp, err := fasthttp_websocket.DefaultDialer.Dial(baseURL+path, nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// frame is a message of the timeline websocket, {"type": key, key: payload}
+type frame map[string]any
+
+func (f frame) kind() string {
+	kind, _ := f["type"].(string)
+	return kind
+}
+
+// status returns the status of status frames and of the start and done frames of lanes
+func (f frame) status() string {
+	payload, _ := f[f.kind()].(map[string]any)
+	status, _ := payload["status"].(string)
+	return status
+}
+
+// readFrames reads frames until done returns true for one, failing after a few seconds
+func readFrames(t *testing.T, conn *fasthttp_websocket.Conn, done func(f frame) bool) []frame {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var frames []frame
+	for {
+		var f frame
+		require.NoError(t, conn.ReadJSON(&f))
+		frames = append(frames, f)
+		if done(f) {
+			return frames
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+var errInvalidLane = errors.New("invalid lane")
+
+// LaneStatsHandler describes the documents of every lane of a site and channel
+func LaneStatsHandler(c *fiber.Ctx) error {
+	siteID, channelID, err := parseParamsSiteIDChannelID(c)
+	if err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	lanes := db.TimelineLanes()
+	// The stats read a few documents of each lane, they are charged like an instant of every lane
+	principal, authenticated := auth.FromCtx(c)
+	if err = acquireBudget(c.Context(), clientKey(principal, authenticated, c.IP()), 0, len(lanes)); err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer cancel()
+	stats := make([]models.LaneStats, 0, len(lanes))
+	for _, lane := range lanes {
+		laneStats, statsErr := timelineStore.Stats(ctx, lane, siteID, channelID)
+		if statsErr != nil {
+			log.Error().Err(statsErr).Str("lane", lane.Name).Int("siteId", siteID).Int("channelId", channelID).Msg("Error reading lane stats")
+			return c.Status(fiber.StatusInternalServerError).SendString("Error reading lane stats")
+		}
+		stats = append(stats, laneStats)
+	}
+	return c.JSON(stats)
+}
+
+// SnapHandler returns the document of the ?lane (recordings by default) of a site and channel
+// nearest to :timeStamp, 404 when the lane is empty
+func SnapHandler(c *fiber.Ctx) error {
+	siteID, channelID, err := parseParamsSiteIDChannelID(c)
+	if err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	timeStamp, err := strconv.ParseInt(c.Params("timeStamp"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(errInvalidTimeStamp.Error())
+	}
+	lane, ok := db.LaneByName(c.Query("lane", db.LaneRecordings))
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).SendString(errInvalidLane.Error())
+	}
+	principal, authenticated := auth.FromCtx(c)
+	if err = acquireBudget(c.Context(), clientKey(principal, authenticated, c.IP()), 0, 1); err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer cancel()
+	doc, found, err := timelineStore.Snap(ctx, lane, siteID, channelID, timeStamp)
+	if err != nil {
+		log.Error().Err(err).Str("lane", lane.Name).Int("siteId", siteID).Int("channelId", channelID).Msg("Error snapping")
+		return c.Status(fiber.StatusInternalServerError).SendString("Error snapping")
+	}
+	if !found {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.JSON(doc)
+}
@@ -0,0 +1,44 @@
+package api_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/ratelimit"
+	"github.com/vtpl1/cacheserver/store"
+)
+
+func TestLaneHandlersBudget(t *testing.T) {
+	m := store.NewMemory()
+	m.Add(db.LaneRecordings, 5, 5, models.Segment{TimeStamp: 1733000000000, TimeStampEnd: 1733003600000})
+	opts := api.DefaultOptions()
+	opts.Store = m
+	opts.PrefetchConcurrency = 0
+	opts.Limiter = ratelimit.New(ratelimit.Options{Default: ratelimit.Limit{Rate: 0.001, Burst: 1}, CostPerLane: 1})
+	api.Configure(opts)
+	t.Cleanup(func() { api.Configure(api.DefaultOptions()) })
+	app := fiber.New()
+	app.Get("site/:siteId/channel/:channelId/stats", api.LaneStatsHandler)
+	app.Get("site/:siteId/channel/:channelId/snap/:timeStamp", api.SnapHandler)
+
+	for _, tc := range []struct {
+		url    string
+		status int
+	}{
+		{"/site/5/channel/5/snap/1733000000000", fiber.StatusOK},
+		{"/site/5/channel/5/snap/1733000000000", fiber.StatusTooManyRequests},
+		// The stats read every lane, more than the burst of the client
+		{"/site/5/channel/6/stats", fiber.StatusTooManyRequests},
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", tc.url, nil), 2000)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, tc.url)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/admission"
+	"github.com/vtpl1/cacheserver/db"
+)
+
+const (
+	defaultPrefetchConcurrency = 4
+	prefetchTimeout            = 30 * time.Second
+)
+
+// prefetch speculatively computes into the cache the buckets of the spans most likely requested
+// after [domainMin, domainMax]: the right and left neighbouring windows and the window one zoom
+// level out. At most cap(prefetchSlots) buckets are computed at a time, prefetching gives up
+// once prefetchTimeout passes.
+func prefetch(siteID int, channelID int, domainMin int64, domainMax int64) {
+	slots := prefetchSlots
+	if cap(slots) == 0 {
+		return
+	}
+	span := domainMax - domainMin
+	spans := [][2]int64{
+		{domainMax, domainMax + span},
+		{max(domainMin-span, 0), domainMin},
+		{max(domainMin-span/2, 0), domainMax + span/2},
+	}
+	var buckets []bucket
+	for _, s := range spans {
+		gap := mergeGap(s[1] - s[0])
+		for _, lane := range db.TimelineLanes() {
+			for _, b := range bucketsOf(lane, siteID, channelID, gap, s[0], s[1]) {
+				// Buckets still settling are never cached
+				if settled(b.end()) {
+					buckets = append(buckets, b)
+				}
+			}
+		}
+	}
+	if len(buckets) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(admission.Background(context.Background()), prefetchTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for _, b := range buckets {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func(b bucket) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if _, err := bucketCache.Get(ctx, b.key()); err != nil {
+					log.Debug().Err(err).Str("bucket", b.key()).Msg("Prefetch failed")
+				}
+			}(b)
+		}
+	}()
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/ratelimit"
+	"github.com/vtpl1/cacheserver/store"
+	"github.com/vtpl1/cacheserver/timelinepb"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TimelineServer serves the timeline lanes over gRPC, from the store and cached buckets of the
+// other handlers
+type TimelineServer struct {
+	timelinepb.UnimplementedTimelineServiceServer
+}
+
+// NewTimelineServer creates a TimelineServer
+func NewTimelineServer() *TimelineServer {
+	return &TimelineServer{}
+}
+
+// grpcStatusOf maps a request error to its gRPC status
+func grpcStatusOf(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ratelimit.ErrRateLimited), errors.Is(err, ratelimit.ErrBudgetExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpcClientKey identifies the client of a call for rate limiting, by subject when authenticated
+func grpcClientKey(ctx context.Context) string {
+	principal, authenticated := auth.FromContext(ctx)
+	ip := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	return clientKey(principal, authenticated, ip)
+}
+
+// admitCall checks a call on a site and channel over [timeStamp, timeStampEnd] reading lanes may
+// run: the range is valid, the principal may read the channel and the client has the budget
+func admitCall(ctx context.Context, siteID int32, channelID int32, timeStamp uint64, timeStampEnd uint64, lanes int) error {
+	if timeStampEnd < timeStamp {
+		return grpcStatusOf(errInvalidTimeRange)
+	}
+	principal, _ := auth.FromContext(ctx)
+	if err := authorize(principal, int(siteID), int(channelID)); err != nil {
+		return grpcStatusOf(err)
+	}
+	if err := acquireBudget(ctx, grpcClientKey(ctx), int64(timeStampEnd-timeStamp), lanes); err != nil {
+		return grpcStatusOf(err)
+	}
+	return nil
+}
+
+func toPBSegment(seg models.Segment) *timelinepb.Segment {
+	return &timelinepb.Segment{TimeStamp: seg.TimeStamp, TimeStampEnd: seg.TimeStampEnd, ObjectCount: seg.ObjectCount}
+}
+
+// GetTimeline implements timelinepb.TimelineServiceServer
+func (s *TimelineServer) GetTimeline(ctx context.Context, req *timelinepb.GetTimelineRequest) (*timelinepb.GetTimelineResponse, error) {
+	if err := admitCall(ctx, req.GetSiteId(), req.GetChannelId(), req.GetTimeStamp(), req.GetTimeStampEnd(), len(db.TimelineLanes())); err != nil {
+		return nil, err
+	}
+	logger := log.With().
+		Int32("siteId", req.GetSiteId()).
+		Int32("channelId", req.GetChannelId()).
+		Uint64("timeStamp", req.GetTimeStamp()).
+		Uint64("timeStampEnd", req.GetTimeStampEnd()).
+		Logger()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	siteID, channelID, timeStamp, timeStampEnd := req.GetSiteId(), req.GetChannelId(), req.GetTimeStamp(), req.GetTimeStampEnd()
+	query := func(laneName string) store.Query {
+		lane, _ := db.DocumentLane(laneName)
+		return timelineQuery(lane, int(siteID), int(channelID), timeStamp, timeStampEnd)
+	}
+	resp := &timelinepb.GetTimelineResponse{}
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		resp.Recordings, err = fetchDocuments(gctx, query(db.LaneRecordings), timeStamp, timeStampEnd, func(doc models.Segment) *timelinepb.Recording {
+			return &timelinepb.Recording{SiteId: siteID, ChannelId: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		resp.Humans, err = fetchDocuments(gctx, query(db.LaneHumans), timeStamp, timeStampEnd, func(doc models.Segment) *timelinepb.Human {
+			return &timelinepb.Human{SiteId: siteID, ChannelId: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		resp.Vehicles, err = fetchDocuments(gctx, query(db.LaneVehicles), timeStamp, timeStampEnd, func(doc models.Segment) *timelinepb.Vehicle {
+			return &timelinepb.Vehicle{SiteId: siteID, ChannelId: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		resp.Events, err = fetchDocuments(gctx, query(db.LaneEvents), timeStamp, timeStampEnd, func(doc models.Segment) *timelinepb.Event {
+			return &timelinepb.Event{SiteId: siteID, ChannelId: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		logger.Error().Err(err).Msg("Error fetching data")
+		return nil, status.Error(codes.Internal, "Error fetching data")
+	}
+	logger.Info().
+		Int("recordings", len(resp.GetRecordings())).
+		Int("humans", len(resp.GetHumans())).
+		Int("vehicles", len(resp.GetVehicles())).
+		Int("events", len(resp.GetEvents())).
+		Msg("Timeline sent")
+	return resp, nil
+}
+
+// StreamTimeline implements timelinepb.TimelineServiceServer
+func (s *TimelineServer) StreamTimeline(req *timelinepb.StreamTimelineRequest, stream timelinepb.TimelineService_StreamTimelineServer) error {
+	ctx := stream.Context()
+	lanes, err := parseLanes(strings.Join(req.GetLanes(), ","))
+	if err != nil {
+		return grpcStatusOf(err)
+	}
+	if err = admitCall(ctx, req.GetSiteId(), req.GetChannelId(), req.GetTimeStamp(), req.GetTimeStampEnd(), len(lanes)); err != nil {
+		return err
+	}
+	domainMin, domainMax := int64(req.GetTimeStamp()), int64(req.GetTimeStampEnd())
+	gap, err := selectGap(domainMax-domainMin, req.GetGap(), req.GetMaxPoints())
+	if err != nil {
+		return grpcStatusOf(err)
+	}
+	logger := log.With().
+		Int32("siteId", req.GetSiteId()).
+		Int32("channelId", req.GetChannelId()).
+		Int64("timeStamp", domainMin).
+		Int64("timeStampEnd", domainMax).
+		Int64("gap", gap).
+		Logger()
+
+	// The lanes are merged concurrently, their batches are sent one at a time
+	var sendMutex sync.Mutex
+	counts := make(map[string]int64, len(lanes))
+	g, gctx := errgroup.WithContext(ctx)
+	for _, lane := range lanes {
+		g.Go(func() error {
+			segs, laneErr := laneSegments(gctx, lane, int(req.GetSiteId()), int(req.GetChannelId()), domainMin, domainMax, gap)
+			if laneErr != nil {
+				logger.Error().Str("fetching", lane.Name).Err(laneErr).Send()
+				return laneErr
+			}
+			sendMutex.Lock()
+			defer sendMutex.Unlock()
+			for start := 0; start < len(segs); start += maxBatchSize {
+				batch := &timelinepb.LaneSegments{Lane: lane.Name}
+				for _, seg := range segs[start:min(start+maxBatchSize, len(segs))] {
+					batch.Segments = append(batch.Segments, toPBSegment(seg))
+				}
+				if laneErr = stream.Send(&timelinepb.StreamTimelineResponse{Message: &timelinepb.StreamTimelineResponse_Segments{Segments: batch}}); laneErr != nil {
+					return laneErr
+				}
+			}
+			counts[lane.Name] = int64(len(segs))
+			return nil
+		})
+	}
+	if err = g.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Error(codes.Internal, "Error fetching data")
+	}
+	logger.Info().Interface("counts", counts).Msg("Timeline streamed")
+	return stream.Send(&timelinepb.StreamTimelineResponse{Message: &timelinepb.StreamTimelineResponse_Done{Done: &timelinepb.TimelineDone{Gap: gap, Counts: counts}}})
+}
+
+// Snap implements timelinepb.TimelineServiceServer
+func (s *TimelineServer) Snap(ctx context.Context, req *timelinepb.SnapRequest) (*timelinepb.SnapResponse, error) {
+	laneName := req.GetLane()
+	if laneName == "" {
+		laneName = db.LaneRecordings
+	}
+	lane, ok := db.LaneByName(laneName)
+	if !ok {
+		return nil, grpcStatusOf(errInvalidLane)
+	}
+	// A snap reads a document or two of one lane, it is charged like an instant
+	if err := admitCall(ctx, req.GetSiteId(), req.GetChannelId(), req.GetTimeStamp(), req.GetTimeStamp(), 1); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	doc, found, err := timelineStore.Snap(ctx, lane, int(req.GetSiteId()), int(req.GetChannelId()), int64(req.GetTimeStamp()))
+	if err != nil {
+		log.Error().Err(err).Str("lane", lane.Name).Int32("siteId", req.GetSiteId()).Int32("channelId", req.GetChannelId()).Msg("Error snapping")
+		return nil, status.Error(codes.Internal, "Error snapping")
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "lane is empty")
+	}
+	return &timelinepb.SnapResponse{Lane: lane.Name, Segment: toPBSegment(doc)}, nil
+}
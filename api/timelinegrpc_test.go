@@ -0,0 +1,152 @@
+package api_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/ratelimit"
+	"github.com/vtpl1/cacheserver/timelinepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCServer serves the timeline service in memory and returns a client of it
+func startGRPCServer(t *testing.T, opts ...grpc.ServerOption) timelinepb.TimelineServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	timelinepb.RegisterTimelineServiceServer(server, api.NewTimelineServer())
+	go server.Serve(listener) //nolint:errcheck
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+	return timelinepb.NewTimelineServiceClient(conn)
+}
+
+func TestTimelineServerGetTimeline(t *testing.T) {
+	configure(t, pageStore())
+	client := startGRPCServer(t)
+
+	resp, err := client.GetTimeline(context.Background(), &timelinepb.GetTimelineRequest{
+		SiteId: 5, ChannelId: 5, TimeStamp: 1733931300000, TimeStampEnd: 1733933600000,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.GetRecordings())
+	require.Len(t, resp.GetHumans(), 2, "humans overlapping the range")
+	assert.Equal(t, uint64(1733931300000), resp.GetHumans()[0].GetTimeStamp())
+	assert.Equal(t, int32(5), resp.GetHumans()[0].GetSiteId())
+	assert.Len(t, resp.GetVehicles(), 2)
+
+	_, err = client.GetTimeline(context.Background(), &timelinepb.GetTimelineRequest{SiteId: 5, ChannelId: 5, TimeStamp: 2, TimeStampEnd: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTimelineServerStreamTimeline(t *testing.T) {
+	configure(t, pageStore())
+	client := startGRPCServer(t)
+
+	stream, err := client.StreamTimeline(context.Background(), &timelinepb.StreamTimelineRequest{
+		SiteId: 5, ChannelId: 5, TimeStamp: 1733930000000, TimeStampEnd: 1733933600000,
+		Lanes: []string{db.LaneHumans, db.LaneVehicles}, Gap: 300000,
+	})
+	require.NoError(t, err)
+	segments := map[string]int{}
+	var done *timelinepb.TimelineDone
+	for {
+		resp, recvErr := stream.Recv()
+		if recvErr == io.EOF {
+			break
+		}
+		require.NoError(t, recvErr)
+		if batch := resp.GetSegments(); batch != nil {
+			segments[batch.GetLane()] += len(batch.GetSegments())
+		}
+		if resp.GetDone() != nil {
+			done = resp.GetDone()
+		}
+	}
+	require.NotNil(t, done, "the stream ends with done")
+	assert.Equal(t, int64(300000), done.GetGap())
+	assert.Equal(t, map[string]int64{db.LaneHumans: 1, db.LaneVehicles: 2}, done.GetCounts(), "five minutes apart humans merge")
+	assert.Equal(t, map[string]int{db.LaneHumans: 1, db.LaneVehicles: 2}, segments)
+
+	stream, err = client.StreamTimeline(context.Background(), &timelinepb.StreamTimelineRequest{
+		SiteId: 5, ChannelId: 5, TimeStamp: 1, TimeStampEnd: 2, Lanes: []string{"faces"},
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTimelineServerSnap(t *testing.T) {
+	configure(t, pageStore())
+	client := startGRPCServer(t)
+
+	resp, err := client.Snap(context.Background(), &timelinepb.SnapRequest{SiteId: 5, ChannelId: 5, Lane: db.LaneVehicles, TimeStamp: 1733931800000})
+	require.NoError(t, err)
+	assert.Equal(t, db.LaneVehicles, resp.GetLane())
+	assert.Equal(t, uint64(1733931900000), resp.GetSegment().GetTimeStamp())
+
+	_, err = client.Snap(context.Background(), &timelinepb.SnapRequest{SiteId: 5, ChannelId: 5, TimeStamp: 1733931800000})
+	assert.Equal(t, codes.NotFound, status.Code(err), "the recordings lane is empty")
+}
+
+func TestTimelineServerAuthorization(t *testing.T) {
+	policy, err := auth.NewPolicy(auth.PolicyFile{Subjects: map[string][]string{"alice": {"5/5"}}})
+	require.NoError(t, err)
+	opts := api.DefaultOptions()
+	opts.Store = pageStore()
+	opts.Policy = policy
+	api.Configure(opts)
+	t.Cleanup(func() { api.Configure(api.DefaultOptions()) })
+	keys := auth.APIKeys{"key-1": "alice"}
+	client := startGRPCServer(t,
+		grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(keys)),
+		grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(keys)))
+
+	_, err = client.Snap(context.Background(), &timelinepb.SnapRequest{SiteId: 5, ChannelId: 5, Lane: db.LaneHumans})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer key-1")
+	_, err = client.Snap(ctx, &timelinepb.SnapRequest{SiteId: 5, ChannelId: 5, Lane: db.LaneHumans})
+	require.NoError(t, err)
+	_, err = client.Snap(ctx, &timelinepb.SnapRequest{SiteId: 5, ChannelId: 6, Lane: db.LaneHumans})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.StreamTimeline(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-1"),
+		&timelinepb.StreamTimelineRequest{SiteId: 5, ChannelId: 6, TimeStamp: 1, TimeStampEnd: 2})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestTimelineServerSnapBudget(t *testing.T) {
+	opts := api.DefaultOptions()
+	opts.Store = pageStore()
+	opts.PrefetchConcurrency = 0
+	opts.Limiter = ratelimit.New(ratelimit.Options{Default: ratelimit.Limit{Rate: 0.001, Burst: 1}, CostPerLane: 1})
+	api.Configure(opts)
+	t.Cleanup(func() { api.Configure(api.DefaultOptions()) })
+	client := startGRPCServer(t)
+
+	_, err := client.Snap(context.Background(), &timelinepb.SnapRequest{SiteId: 5, ChannelId: 5, Lane: db.LaneVehicles, TimeStamp: 1733931800000})
+	require.NoError(t, err)
+	_, err = client.Snap(context.Background(), &timelinepb.SnapRequest{SiteId: 5, ChannelId: 5, Lane: db.LaneVehicles, TimeStamp: 1733931800000})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -2,25 +2,34 @@
 package api
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
 	"github.com/vtpl1/cacheserver/db"
 	"github.com/vtpl1/cacheserver/models"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/vtpl1/cacheserver/store"
 )
 
-// TimeLineHandler handles timeline requests
-func TimeLineHandler(c *fiber.Ctx) error {
+const (
+	mimeNDJSON = "application/x-ndjson"
+	// streamTimeout bounds a streamed response, which unlike a buffered one may span a long range
+	streamTimeout = 5 * time.Minute
+)
+
+// TimeLineHandler handles timeline requests. Clients accepting application/x-ndjson get the
+// documents streamed as they are read, streams end when ctx is done.
+func TimeLineHandler(ctx context.Context, c *fiber.Ctx) error {
 	siteID, channelID, timeStamp, timeStampEnd, err := parseParams(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		return c.Status(statusOf(err)).SendString(err.Error())
 	}
 
 	logger := log.With().
@@ -34,12 +43,14 @@ func TimeLineHandler(c *fiber.Ctx) error {
 		logger.Error().Msg("Invalid time range")
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid time range")
 	}
-	client, err := db.GetDefaultMongoClient()
-	if err != nil {
-		logger.Error().Err(err).Msg("Error connecting to MongoDB")
-		return c.Status(fiber.StatusInternalServerError).SendString("Error connecting to MongoDB")
+	principal, authenticated := auth.FromCtx(c)
+	if err = acquireBudget(c.Context(), clientKey(principal, authenticated, c.IP()), int64(timeStampEnd-timeStamp), len(db.TimelineLanes())); err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	if c.Accepts(fiber.MIMEApplicationJSON, mimeNDJSON) == mimeNDJSON {
+		streamTimeline(ctx, c, siteID, channelID, timeStamp, timeStampEnd, logger)
+		return nil
 	}
-
 	timeline := models.NewTimeLineResponse()
 	ctx, cancel := context.WithTimeout(c.Context(), 5*time.Second)
 	defer cancel()
@@ -54,42 +65,43 @@ func TimeLineHandler(c *fiber.Ctx) error {
 	var vehiclesQueryErr error
 	var eventsQueryErr error
 
+	query := func(laneName string) store.Query {
+		lane, _ := db.DocumentLane(laneName)
+		return timelineQuery(lane, siteID, channelID, timeStamp, timeStampEnd)
+	}
+
 	wg.Add(4) // We have 4 goroutines to wait for
-	filterTimeStamp := bson.M{"$or": []bson.M{
-		{"startTimestamp": bson.M{"$gte": timeStamp, "$lte": timeStampEnd}},
-		{"endTimestamp": bson.M{"$gte": timeStamp, "$lte": timeStampEnd}},
-		{"$and": []bson.M{
-			{"startTimestamp": bson.M{"$lte": timeStamp}},
-			{"endTimestamp": bson.M{"$gte": timeStampEnd}},
-		}},
-	}}
 
 	// Fetch recordings in parallel
 	go func() {
 		defer wg.Done()
-		recordings, recordingsQueryErr = fetchRecordings(ctx, client.Database("ivms_30"), fmt.Sprintf("vVideoClips_%d_%d", siteID, channelID), filterTimeStamp, siteID, channelID)
+		recordings, recordingsQueryErr = fetchDocuments(ctx, query(db.LaneRecordings), timeStamp, timeStampEnd, func(doc models.Segment) models.Recording {
+			return models.Recording{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
 	}()
 
 	// Fetch humans in parallel
 	go func() {
 		defer wg.Done()
-		humans, humansQueryErr = fetchHumans(ctx, client.Database("pvaDB"), fmt.Sprintf("pva_HUMAN_%d_%d", siteID, channelID), filterTimeStamp, siteID, channelID)
+		humans, humansQueryErr = fetchDocuments(ctx, query(db.LaneHumans), timeStamp, timeStampEnd, func(doc models.Segment) models.Human {
+			return models.Human{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
 	}()
 
 	// Fetch vehicles in parallel
 	go func() {
 		defer wg.Done()
-		vehicles, vehiclesQueryErr = fetchVehicles(ctx, client.Database("pvaDB"), fmt.Sprintf("pva_VEHICLE_%d_%d", siteID, channelID), filterTimeStamp, siteID, channelID)
+		vehicles, vehiclesQueryErr = fetchDocuments(ctx, query(db.LaneVehicles), timeStamp, timeStampEnd, func(doc models.Segment) models.Vehicle {
+			return models.Vehicle{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
 	}()
 
 	// Fetch events in parallel
 	go func() {
 		defer wg.Done()
-		events, eventsQueryErr = fetchEvents(ctx, client.Database("dasEvents"), "dasEvents", bson.M{
-			"siteId":         siteID,
-			"channelId":      channelID,
-			"startTimestamp": bson.M{"$gte": timeStamp, "$lte": timeStampEnd},
-		}, siteID, channelID)
+		events, eventsQueryErr = fetchDocuments(ctx, query(db.LaneEvents), timeStamp, timeStampEnd, func(doc models.Segment) models.Event {
+			return models.Event{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+		})
 	}()
 
 	// Wait for all goroutines to complete
@@ -132,6 +144,107 @@ func TimeLineHandler(c *fiber.Ctx) error {
 		logger.Error().Err(err).Msg("Error marshaling JSON")
 		return c.Status(fiber.StatusInternalServerError).SendString("Error marshaling JSON")
 	}
-
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 	return c.Send(data)
 }
+
+// fetchDocuments admits and reads the documents of q, converted with convert
+func fetchDocuments[T any](ctx context.Context, q store.Query, timeStamp uint64, timeStampEnd uint64, convert func(doc models.Segment) T) ([]T, error) {
+	release, err := admit(ctx, int64(timeStamp), int64(timeStampEnd))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	docs, err := timelineStore.Documents(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if docs == nil {
+		return nil, nil
+	}
+	results := make([]T, len(docs))
+	for i, doc := range docs {
+		results[i] = convert(doc)
+	}
+	return results, nil
+}
+
+// timelineQuery returns the query of the documents of a lane overlapping [timeStamp,
+// timeStampEnd], of the events starting in it
+func timelineQuery(lane db.Lane, siteID int, channelID int, timeStamp uint64, timeStampEnd uint64) store.Query {
+	if lane.Name == db.LaneEvents {
+		return store.Query{
+			Lane: lane, SiteID: siteID, ChannelID: channelID,
+			StartMin: int64(timeStamp), StartMax: int64(timeStampEnd) + 1,
+		}
+	}
+	return store.Query{
+		Lane: lane, SiteID: siteID, ChannelID: channelID,
+		StartMax: int64(timeStampEnd) + 1, EndMin: int64(timeStamp), Reach: int64(timeStampEnd),
+	}
+}
+
+// streamTimeline writes the documents of the timeline lanes one per line as the store reads
+// them, {"type": lane, lane: document} with the document as in the buffered response, and a last
+// status line with the counts of the lanes. The lanes are read one after the other so that only
+// the current document is held. The status of the response is sent before the documents, so
+// failures end the stream with an error line. The reads stop when ctx is done or a write fails.
+func streamTimeline(ctx context.Context, c *fiber.Ctx, siteID int, channelID int, timeStamp uint64, timeStampEnd uint64, logger zerolog.Logger) {
+	c.Set(fiber.HeaderContentType, mimeNDJSON)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		streamCtx, cancel := context.WithTimeout(ctx, streamTimeout)
+		defer cancel()
+		encoder := json.NewEncoder(w)
+		counts := make(map[string]int, len(db.DocumentLanes()))
+		for _, lane := range db.DocumentLanes() {
+			var writeErr error
+			err := scanLane(streamCtx, timelineQuery(lane, siteID, channelID, timeStamp, timeStampEnd), timeStamp, timeStampEnd, func(doc models.Segment) error {
+				counts[lane.Name]++
+				writeErr = encoder.Encode(fiber.Map{"type": lane.Name, lane.Name: laneDocument(lane.Name, siteID, channelID, doc)})
+				return writeErr
+			})
+			if err == nil {
+				err = w.Flush()
+				writeErr = err
+			}
+			if writeErr != nil {
+				// The client is gone
+				cancel()
+				logger.Error().Err(writeErr).Str("lane", lane.Name).Msg("Error writing stream")
+				return
+			}
+			if err != nil {
+				logger.Error().Err(err).Str("lane", lane.Name).Msg("Error streaming data")
+				_ = encoder.Encode(fiber.Map{"type": "error", "error": "Error streaming data"})
+				_ = w.Flush()
+				return
+			}
+			logger.Info().Int("count", counts[lane.Name]).Str("lane", lane.Name).Msg("Streamed")
+		}
+		_ = encoder.Encode(fiber.Map{"type": "status", "status": models.StreamStatus{Status: "done", Counts: counts}})
+		_ = w.Flush()
+	})
+}
+
+// laneDocument returns doc as the buffered response lists it in the lane called laneName
+func laneDocument(laneName string, siteID int, channelID int, doc models.Segment) any {
+	switch laneName {
+	case db.LaneHumans:
+		return models.Human{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+	case db.LaneVehicles:
+		return models.Vehicle{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+	case db.LaneEvents:
+		return models.Event{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+	}
+	return models.Recording{SiteID: siteID, ChannelID: channelID, TimeStamp: doc.TimeStamp, TimeStampEnd: doc.TimeStampEnd}
+}
+
+// scanLane admits and scans the documents of q
+func scanLane(ctx context.Context, q store.Query, timeStamp uint64, timeStampEnd uint64, fn func(doc models.Segment) error) error {
+	release, err := admit(ctx, int64(timeStamp), int64(timeStampEnd))
+	if err != nil {
+		return err
+	}
+	defer release()
+	return timelineStore.Scan(ctx, q, fn)
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+// sseEndID is the id of the event closing the stream of a command. A client reconnecting with it
+// gets 204 No Content, which stops an EventSource.
+const sseEndID = "end"
+
+var (
+	errInvalidDomain      = errors.New("invalid domainMin or domainMax")
+	errInvalidLastEventID = errors.New("invalid Last-Event-ID")
+)
+
+// sseSink writes the messages as server-sent events named after their key. The id of an event
+// counts the messages sent so far per key, a resumed command skips that many messages of each
+// key. Transient messages carry no id and are never skipped.
+type sseSink struct {
+	w     *bufio.Writer
+	mutex sync.Mutex
+	// cancel stops the command once the client is gone
+	cancel context.CancelFunc
+	sent   map[string]int
+	skip   map[string]int
+	err    error
+}
+
+func newSSESink(w *bufio.Writer, cancel context.CancelFunc, skip map[string]int) *sseSink {
+	return &sseSink{w: w, cancel: cancel, sent: make(map[string]int), skip: skip}
+}
+
+func (s *sseSink) send(msgKey string, msg any) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent[msgKey]++
+	if s.sent[msgKey] <= s.skip[msgKey] {
+		return s.err
+	}
+	return s.write(msgKey, fiber.Map{"type": msgKey, msgKey: msg}, formatEventID(s.sent))
+}
+
+func (s *sseSink) notify(msgKey string, msg any) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(msgKey, fiber.Map{"type": msgKey, msgKey: msg}, "")
+}
+
+// end writes the event closing the stream of a command that ran to completion
+func (s *sseSink) end() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(sseEndID, fiber.Map{"type": sseEndID}, sseEndID)
+}
+
+func (s *sseSink) write(event string, data any, id string) error {
+	if s.err != nil {
+		return s.err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, _ = fmt.Fprintf(s.w, "id: %s\n", id)
+	}
+	_, _ = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload)
+	if err = s.w.Flush(); err != nil {
+		s.err = err
+		s.cancel()
+	}
+	return err
+}
+
+// formatEventID returns the event id of the message counts, "humans=3,status=1"
+func formatEventID(counts map[string]int) string {
+	keys := slices.Sorted(maps.Keys(counts))
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "=" + strconv.Itoa(counts[key])
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseEventID parses an event id of formatEventID, an empty id counts nothing
+func parseEventID(id string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if id == "" {
+		return counts, nil
+	}
+	for _, part := range strings.Split(id, ",") {
+		key, count, ok := strings.Cut(part, "=")
+		if !ok || key == "" {
+			return nil, errInvalidLastEventID
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, errInvalidLastEventID
+		}
+		counts[key] = n
+	}
+	return counts, nil
+}
+
+// parseSSECommand parses the command of an event stream from the query parameters commandId,
+// domainMin, domainMax, mode, bins, minGap and expression, the JSON of an Expression
+func parseSSECommand(c *fiber.Ctx) (models.Command, error) {
+	cmd := models.Command{
+		CommandID: c.Query("commandId"),
+		Mode:      c.Query("mode"),
+	}
+	bins, err := queryPositive(c, "bins", errInvalidBins)
+	if err != nil || bins > maxDensityBins {
+		return cmd, errInvalidBins
+	}
+	cmd.Bins = int(bins)
+	if cmd.MinGap, err = queryPositive(c, "minGap", errInvalidMinGap); err != nil {
+		return cmd, err
+	}
+	if cmd.DomainMin, err = strconv.Atoi(c.Query("domainMin")); err != nil {
+		return cmd, errInvalidDomain
+	}
+	if cmd.DomainMax, err = strconv.Atoi(c.Query("domainMax")); err != nil {
+		return cmd, errInvalidDomain
+	}
+	if cmd.DomainMax < cmd.DomainMin {
+		return cmd, errInvalidTimeRange
+	}
+	if expression := c.Query("expression"); expression != "" {
+		cmd.Expression = &models.Expression{}
+		if err = json.Unmarshal([]byte(expression), cmd.Expression); err != nil {
+			return cmd, fmt.Errorf("%w: %w", errInvalidExpression, err)
+		}
+	}
+	if err = validateMode(&cmd); err != nil {
+		return cmd, err
+	}
+	return cmd, nil
+}
+
+// TimeLineSSEHandler streams the results of the command given in the query as server-sent
+// events, with the messages of the websocket. A client reconnecting with Last-Event-ID resumes
+// after the messages it received. Streams end when ctx is done.
+func TimeLineSSEHandler(ctx context.Context, c *fiber.Ctx) error {
+	siteID, channelID, err := parseParamsSiteIDChannelID(c)
+	if err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == sseEndID {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	skip, err := parseEventID(lastEventID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	cmd, err := parseSSECommand(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	principal, authenticated := auth.FromCtx(c)
+	client := clientKey(principal, authenticated, c.IP())
+	logger := log.With().Int("siteId", siteID).Int("channelId", channelID).Str("client", client).Str("resume", lastEventID).Logger()
+	logger.Info().Msg("command:" + fmt.Sprint(cmd))
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		cmdCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		out := newSSESink(w, cancel, skip)
+		writeResults(cmdCtx, cmd, out, client, siteID, channelID, &logger)
+		if cmdCtx.Err() == nil {
+			_ = out.end()
+		}
+	})
+	return nil
+}
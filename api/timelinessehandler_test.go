@@ -0,0 +1,151 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/store"
+)
+
+// event is a server-sent event
+type event struct {
+	id    string
+	name  string
+	frame frame
+}
+
+func sseApp() *fiber.App {
+	app := fiber.New()
+	app.Get("sse/timeline/site/:siteId/channel/:channelId", func(c *fiber.Ctx) error {
+		return api.TimeLineSSEHandler(context.Background(), c)
+	})
+	return app
+}
+
+// streamEvents requests an event stream and reads its events
+func streamEvents(t *testing.T, app *fiber.App, query url.Values, lastEventID string) (int, []event) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/sse/timeline/site/1/channel/1?"+query.Encode(), nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := app.Test(req, 5000)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != fiber.StatusOK {
+		return resp.StatusCode, nil
+	}
+	assert.Equal(t, "text/event-stream", resp.Header.Get(fiber.HeaderContentType))
+
+	var events []event
+	var e event
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			e.id = value
+		case "event":
+			e.name = value
+		case "data":
+			require.NoError(t, json.Unmarshal([]byte(value), &e.frame))
+		case "":
+			events = append(events, e)
+			e = event{}
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return resp.StatusCode, events
+}
+
+// byName groups the data of events by name, in order
+func byName(events []event) map[string][]frame {
+	frames := make(map[string][]frame)
+	for _, e := range events {
+		frames[e.name] = append(frames[e.name], e.frame)
+	}
+	return frames
+}
+
+func sseCommand() url.Values {
+	return url.Values{"commandId": {"7"}, "domainMin": {"1732271925859"}, "domainMax": {"1735717489000"}}
+}
+
+func TestTimeLineSSEHandler(t *testing.T) {
+	configure(t, fixtureStore(t))
+	app := sseApp()
+
+	status, events := streamEvents(t, app, sseCommand(), "")
+	require.Equal(t, fiber.StatusOK, status)
+	require.NotEmpty(t, events)
+	assert.Equal(t, "status", events[0].name)
+	assert.Equal(t, "start", events[0].frame.status())
+	last := events[len(events)-1]
+	assert.Equal(t, "end", last.name)
+	assert.Equal(t, "end", last.id)
+	done := events[len(events)-2]
+	assert.Equal(t, "done", done.frame.status())
+
+	// Events carry the websocket messages, {"type": name, name: payload}
+	for _, e := range events {
+		assert.Equal(t, e.name, e.frame.kind())
+	}
+	assert.NotEmpty(t, byName(events)[db.LaneHumans])
+}
+
+func TestTimeLineSSEHandlerResume(t *testing.T) {
+	configure(t, fixtureStore(t))
+	app := sseApp()
+
+	_, whole := streamEvents(t, app, sseCommand(), "")
+	require.Greater(t, len(whole), 4)
+	resumeAt := whole[3]
+	require.NotEmpty(t, resumeAt.id)
+
+	status, resumed := streamEvents(t, app, sseCommand(), resumeAt.id)
+	require.Equal(t, fiber.StatusOK, status)
+
+	// Lanes interleave differently, but each kind resumes after the messages received
+	received := byName(whole[:4])
+	wholeByName := byName(whole)
+	for name, frames := range byName(resumed) {
+		assert.Equal(t, wholeByName[name][len(received[name]):], frames, name)
+	}
+	assert.Equal(t, whole[len(whole)-1], resumed[len(resumed)-1])
+
+	status, _ = streamEvents(t, app, sseCommand(), "end")
+	assert.Equal(t, fiber.StatusNoContent, status)
+}
+
+func TestTimeLineSSEHandlerInvalidParams(t *testing.T) {
+	configure(t, store.NewMemory())
+	app := sseApp()
+
+	for _, query := range []url.Values{
+		{"domainMin": {"1"}},
+		{"domainMin": {"2"}, "domainMax": {"1"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"heatmap"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"correlate"}, "expression": {"{"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"density"}, "bins": {"abc"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"density"}, "bins": {"0"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"density"}, "bins": {"5001"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"coverage"}, "minGap": {"-5"}},
+		{"domainMin": {"1"}, "domainMax": {"2"}, "mode": {"coverage"}, "minGap": {"abc"}},
+	} {
+		status, _ := streamEvents(t, app, query, "")
+		assert.Equal(t, fiber.StatusBadRequest, status, query.Encode())
+	}
+	status, _ := streamEvents(t, app, sseCommand(), "humans")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+}
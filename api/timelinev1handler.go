@@ -0,0 +1,278 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultPageSize = 1000
+	maxPageSize     = 10000
+
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+var (
+	errInvalidGap       = errors.New("invalid gap, expected at least 100 milliseconds")
+	errInvalidMaxPoints = errors.New("invalid maxPoints, expected a positive number")
+	errInvalidLimit     = errors.New("invalid limit, expected 1 to 10000")
+	errInvalidOrder     = errors.New("invalid order, expected asc or desc")
+	errInvalidCursor    = errors.New("invalid cursor")
+)
+
+// pageRequest is a query of the merged segments of several lanes, one page at a time
+type pageRequest struct {
+	siteID       int
+	channelID    int
+	timeStamp    uint64
+	timeStampEnd uint64
+	lanes        []db.Lane
+	gap          int64
+	limit        int
+	order        string
+	// after is the position of the last segment of the previous page, nil on the first page
+	after *models.LaneSegment
+}
+
+// parsePageRequest parses a page request from the path parameters siteId and channelId and the
+// query parameters timeStamp, timeStampEnd, lanes, gap, maxPoints, limit, order and cursor
+func parsePageRequest(c *fiber.Ctx) (pageRequest, error) {
+	siteID, channelID, err := parseParamsSiteIDChannelID(c)
+	if err != nil {
+		return pageRequest{}, err
+	}
+	req := pageRequest{siteID: siteID, channelID: channelID, order: c.Query("order", orderAsc)}
+	if req.timeStamp, err = strconv.ParseUint(c.Query("timeStamp"), 10, 64); err != nil {
+		return pageRequest{}, errInvalidTimeStamp
+	}
+	if req.timeStampEnd, err = strconv.ParseUint(c.Query("timeStampEnd"), 10, 64); err != nil {
+		return pageRequest{}, errInvalidTimeStampEnd
+	}
+	if req.timeStampEnd < req.timeStamp {
+		return pageRequest{}, errInvalidTimeRange
+	}
+	if req.lanes, err = parseLanes(c.Query("lanes")); err != nil {
+		return pageRequest{}, err
+	}
+
+	if req.gap, err = parseGap(c, int64(req.timeStampEnd-req.timeStamp)); err != nil {
+		return pageRequest{}, err
+	}
+
+	limit, err := queryPositive(c, "limit", errInvalidLimit)
+	if err != nil {
+		return pageRequest{}, err
+	}
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		return pageRequest{}, errInvalidLimit
+	}
+	req.limit = int(limit)
+	if req.order != orderAsc && req.order != orderDesc {
+		return pageRequest{}, errInvalidOrder
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, cursorErr := decodeCursor(cursor)
+		if cursorErr != nil {
+			return pageRequest{}, cursorErr
+		}
+		req.after = &after
+	}
+	return req, nil
+}
+
+// queryPositive parses the query parameter name as a positive integer, zero when it is absent
+func queryPositive(c *fiber.Ctx, name string, invalid error) (int64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 {
+		return 0, invalid
+	}
+	return n, nil
+}
+
+// parseGap returns the merge gap of span selected by the query parameters gap and maxPoints
+func parseGap(c *fiber.Ctx, span int64) (int64, error) {
+	gap, err := queryPositive(c, "gap", errInvalidGap)
+	if err != nil {
+		return 0, err
+	}
+	maxPoints, err := queryPositive(c, "maxPoints", errInvalidMaxPoints)
+	if err != nil {
+		return 0, err
+	}
+	return selectGap(span, gap, maxPoints)
+}
+
+// selectGap returns the merge gap of a span, the larger of gap and the one keeping each lane
+// within maxPoints segments. Zero leaves either unset, the span decides without both.
+func selectGap(span int64, gap int64, maxPoints int64) (int64, error) {
+	if gap != 0 && gap < minMergeGap {
+		return 0, errInvalidGap
+	}
+	if maxPoints < 0 {
+		return 0, errInvalidMaxPoints
+	}
+	if maxPoints > 0 {
+		gap = max(gap, pointsGap(span, maxPoints))
+	}
+	if gap == 0 {
+		gap = mergeGap(span)
+	}
+	return gap, nil
+}
+
+// parseLanes parses a comma separated list of lane names, all the timeline lanes when empty
+func parseLanes(names string) ([]db.Lane, error) {
+	if names == "" {
+		return db.TimelineLanes(), nil
+	}
+	var lanes []db.Lane
+	for _, name := range strings.Split(names, ",") {
+		lane, ok := db.LaneByName(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("%w %q", errInvalidLane, name)
+		}
+		if !slices.ContainsFunc(lanes, func(l db.Lane) bool { return l.Name == lane.Name }) {
+			lanes = append(lanes, lane)
+		}
+	}
+	return lanes, nil
+}
+
+// encodeCursor returns the opaque cursor resuming after seg
+func encodeCursor(seg models.LaneSegment) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d/%s", seg.TimeStamp, seg.Lane))
+}
+
+func decodeCursor(cursor string) (models.LaneSegment, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return models.LaneSegment{}, errInvalidCursor
+	}
+	timeStamp, lane, ok := strings.Cut(string(data), "/")
+	if !ok {
+		return models.LaneSegment{}, errInvalidCursor
+	}
+	var seg models.LaneSegment
+	if seg.TimeStamp, err = strconv.ParseUint(timeStamp, 10, 64); err != nil {
+		return models.LaneSegment{}, errInvalidCursor
+	}
+	seg.Lane = lane
+	return seg, nil
+}
+
+// compareLaneSegments orders segments by start then lane name, the segments of a lane never share
+// a start so the order is total
+func compareLaneSegments(a models.LaneSegment, b models.LaneSegment) int {
+	return cmp.Or(cmp.Compare(a.TimeStamp, b.TimeStamp), strings.Compare(a.Lane, b.Lane))
+}
+
+// timelinePage returns a page of the segments of the lanes of req, merged like the websocket
+// segments from the same cached buckets
+func timelinePage(ctx context.Context, req pageRequest) (models.TimelinePage, error) {
+	results := make([][]models.Segment, len(req.lanes))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, lane := range req.lanes {
+		g.Go(func() error {
+			var err error
+			results[i], err = laneSegments(gctx, lane, req.siteID, req.channelID, int64(req.timeStamp), int64(req.timeStampEnd), req.gap)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return models.TimelinePage{}, err
+	}
+
+	var all []models.LaneSegment
+	for i, segs := range results {
+		for _, seg := range segs {
+			all = append(all, models.LaneSegment{Lane: req.lanes[i].Name, Segment: seg})
+		}
+	}
+	compare := compareLaneSegments
+	if req.order == orderDesc {
+		compare = func(a models.LaneSegment, b models.LaneSegment) int { return compareLaneSegments(b, a) }
+	}
+	slices.SortFunc(all, compare)
+
+	from := 0
+	if req.after != nil {
+		from, _ = slices.BinarySearchFunc(all, *req.after, compare)
+		if from < len(all) && compare(all[from], *req.after) == 0 {
+			from++
+		}
+	}
+	to := min(from+req.limit, len(all))
+	page := models.TimelinePage{
+		SiteID:       req.siteID,
+		ChannelID:    req.channelID,
+		TimeStamp:    req.timeStamp,
+		TimeStampEnd: req.timeStampEnd,
+		Gap:          req.gap,
+		Order:        req.order,
+		Segments:     slices.Clip(all[from:to]),
+	}
+	if page.Segments == nil {
+		page.Segments = []models.LaneSegment{}
+	}
+	if to < len(all) {
+		page.NextCursor = encodeCursor(all[to-1])
+	}
+	return page, nil
+}
+
+// TimelineV1Handler serves a page of the merged segments of a site and channel over
+// [?timeStamp, ?timeStampEnd]. ?lanes lists the lanes, all by default. ?gap and ?maxPoints set
+// the merge gap, by default it follows the span like the websocket. ?limit bounds the page,
+// ?order sorts by start asc or desc and ?cursor takes the nextCursor of the previous page.
+// Each page merges the whole range before cutting the page out: later pages read the buckets
+// cached by the first one, but still sort all the segments of the range and query the live tail
+// and the documents starting before the range again.
+func TimelineV1Handler(c *fiber.Ctx) error {
+	req, err := parsePageRequest(c)
+	if err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+	logger := log.With().
+		Int("siteId", req.siteID).
+		Int("channelId", req.channelID).
+		Uint64("timeStamp", req.timeStamp).
+		Uint64("timeStampEnd", req.timeStampEnd).
+		Int64("gap", req.gap).
+		Logger()
+	principal, authenticated := auth.FromCtx(c)
+	if err = acquireBudget(c.Context(), clientKey(principal, authenticated, c.IP()), int64(req.timeStampEnd-req.timeStamp), len(req.lanes)); err != nil {
+		return c.Status(statusOf(err)).SendString(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer cancel()
+	page, err := timelinePage(ctx, req)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error fetching data")
+		return c.Status(fiber.StatusInternalServerError).SendString("Error fetching data")
+	}
+	logger.Info().Int("count", len(page.Segments)).Bool("more", page.NextCursor != "").Msg("Timeline page sent")
+	return c.JSON(page)
+}
@@ -0,0 +1,165 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/store"
+)
+
+// pageStore holds a few minutes apart humans and vehicles of site 5 channel 5
+func pageStore() *store.Memory {
+	m := store.NewMemory()
+	m.Add(db.LaneHumans, 5, 5,
+		models.Segment{TimeStamp: 1733931000000, TimeStampEnd: 1733931060000, ObjectCount: 1},
+		models.Segment{TimeStamp: 1733931300000, TimeStampEnd: 1733931360000, ObjectCount: 2},
+		models.Segment{TimeStamp: 1733931600000, TimeStampEnd: 1733931660000, ObjectCount: 3},
+	)
+	m.Add(db.LaneVehicles, 5, 5,
+		models.Segment{TimeStamp: 1733931300000, TimeStampEnd: 1733931330000, ObjectCount: 4},
+		models.Segment{TimeStamp: 1733931900000, TimeStampEnd: 1733931930000, ObjectCount: 5},
+	)
+	return m
+}
+
+func getPage(t *testing.T, app *fiber.App, query url.Values) (int, models.TimelinePage) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/timeline/site/5/channel/5?"+query.Encode(), nil)
+	resp, err := app.Test(req, 2000)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	var page models.TimelinePage
+	if resp.StatusCode == fiber.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	}
+	return resp.StatusCode, page
+}
+
+func pageQuery(params ...string) url.Values {
+	query := url.Values{"timeStamp": {"1733930000000"}, "timeStampEnd": {"1733933600000"}}
+	for i := 0; i+1 < len(params); i += 2 {
+		query.Set(params[i], params[i+1])
+	}
+	return query
+}
+
+func TestTimelineV1HandlerPagination(t *testing.T) {
+	configure(t, pageStore())
+	app := fiber.New()
+	app.Get("api/v1/timeline/site/:siteId/channel/:channelId", api.TimelineV1Handler)
+
+	for _, order := range []string{"asc", "desc"} {
+		status, whole := getPage(t, app, pageQuery("order", order))
+		require.Equal(t, fiber.StatusOK, status)
+		require.Len(t, whole.Segments, 5)
+		assert.Empty(t, whole.NextCursor)
+		assert.Equal(t, order, whole.Order)
+		assert.Equal(t, int64(720), whole.Gap, "an hour is merged like the websocket")
+
+		var paged []models.LaneSegment
+		cursor := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 5)
+			status, page := getPage(t, app, pageQuery("order", order, "limit", "2", "cursor", cursor))
+			require.Equal(t, fiber.StatusOK, status)
+			assert.LessOrEqual(t, len(page.Segments), 2)
+			paged = append(paged, page.Segments...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		assert.Equal(t, whole.Segments, paged, "the pages of %s add up to the whole", order)
+	}
+
+	_, asc := getPage(t, app, pageQuery())
+	assert.Equal(t, models.LaneSegment{Lane: db.LaneHumans, Segment: models.Segment{TimeStamp: 1733931300000, TimeStampEnd: 1733931360000, ObjectCount: 2}}, asc.Segments[1])
+	assert.Equal(t, db.LaneVehicles, asc.Segments[2].Lane, "segments starting together are ordered by lane")
+	_, desc := getPage(t, app, pageQuery("order", "desc"))
+	slices.Reverse(desc.Segments)
+	assert.Equal(t, asc.Segments, desc.Segments)
+}
+
+func TestTimelineV1HandlerLanesAndGap(t *testing.T) {
+	configure(t, pageStore())
+	app := fiber.New()
+	app.Get("api/v1/timeline/site/:siteId/channel/:channelId", api.TimelineV1Handler)
+
+	status, page := getPage(t, app, pageQuery("lanes", "vehicles"))
+	require.Equal(t, fiber.StatusOK, status)
+	require.Len(t, page.Segments, 2)
+	for _, seg := range page.Segments {
+		assert.Equal(t, db.LaneVehicles, seg.Lane)
+	}
+
+	// Five minutes apart humans merge within a gap of 300000 ms
+	status, page = getPage(t, app, pageQuery("lanes", "humans", "gap", "300000"))
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, int64(300000), page.Gap)
+	require.Len(t, page.Segments, 1)
+	assert.Equal(t, models.Segment{TimeStamp: 1733931000000, TimeStampEnd: 1733931660000, ObjectCount: 6}, page.Segments[0].Segment)
+
+	status, page = getPage(t, app, pageQuery("lanes", "humans", "maxPoints", "10"))
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, int64(360000), page.Gap, "a tenth of an hour")
+	assert.Len(t, page.Segments, 1)
+}
+
+func TestTimelineV1HandlerLateDocuments(t *testing.T) {
+	m := store.NewMemory()
+	now := time.Now().UnixMilli()
+	minute := time.Minute.Milliseconds()
+	m.Add(db.LaneHumans, 5, 5, models.Segment{TimeStamp: uint64(now - 40*minute), TimeStampEnd: uint64(now - 39*minute)})
+	configure(t, m)
+	app := fiber.New()
+	app.Get("api/v1/timeline/site/:siteId/channel/:channelId", api.TimelineV1Handler)
+	query := url.Values{"timeStamp": {strconv.FormatInt(now-50*minute, 10)}, "timeStampEnd": {strconv.FormatInt(now-10*minute, 10)}, "lanes": {"humans"}}
+
+	status, page := getPage(t, app, query)
+	require.Equal(t, fiber.StatusOK, status)
+	require.Len(t, page.Segments, 1)
+
+	// Older than the live tail but within the settle window, the buckets were not cached
+	m.Add(db.LaneHumans, 5, 5, models.Segment{TimeStamp: uint64(now - 20*minute), TimeStampEnd: uint64(now - 19*minute)})
+	status, page = getPage(t, app, query)
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Len(t, page.Segments, 2, "late documents are seen")
+}
+
+func TestTimelineV1HandlerInvalidParams(t *testing.T) {
+	configure(t, store.NewMemory())
+	app := fiber.New()
+	app.Get("api/v1/timeline/site/:siteId/channel/:channelId", api.TimelineV1Handler)
+
+	for _, query := range []url.Values{
+		{"timeStamp": {"1733930000000"}},
+		pageQuery("timeStampEnd", "1"),
+		pageQuery("lanes", "humans,faces"),
+		pageQuery("gap", "50"),
+		pageQuery("gap", "-1000"),
+		pageQuery("gap", "1s"),
+		pageQuery("maxPoints", "-1"),
+		pageQuery("maxPoints", "0"),
+		pageQuery("maxPoints", "ten"),
+		pageQuery("limit", "0"),
+		pageQuery("limit", "-5"),
+		pageQuery("limit", "all"),
+		pageQuery("limit", "10001"),
+		pageQuery("order", "up"),
+		pageQuery("cursor", "!"),
+	} {
+		status, _ := getPage(t, app, query)
+		assert.Equal(t, fiber.StatusBadRequest, status, query.Encode())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,10 +12,11 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/admission"
+	"github.com/vtpl1/cacheserver/auth"
 	"github.com/vtpl1/cacheserver/db"
 	"github.com/vtpl1/cacheserver/models"
-	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"github.com/vtpl1/cacheserver/segments"
 )
 
 const (
@@ -35,448 +37,324 @@ const (
 	maxTimeGapAllowedInmSecForYear      = 12 * maxTimeGapAllowedInmSecForMonth
 )
 
+const (
+	defaultDensityBins = 500
+	maxDensityBins     = 5000
+)
+
 var (
 	errInvalidTimeRange = errors.New("invalid time range")
 	errInvalidCommand   = errors.New("invalid command")
+	errInvalidMode      = errors.New("invalid mode, expected segments, density, coverage or correlate")
+	errInvalidBins      = errors.New("invalid bins, expected 1 to 5000")
 )
 
-type collectionConfig struct {
-	name       string
-	dbName     string
-	collName   string
-	resultType interface{}
-	filter     bson.A
-	commandID  string
+// validateMode checks the mode of a command and fills in its defaults
+func validateMode(cmd *models.Command) error {
+	switch cmd.Mode {
+	case "":
+		cmd.Mode = models.ModeSegments
+	case models.ModeSegments:
+	case models.ModeDensity:
+		if cmd.Bins == 0 {
+			cmd.Bins = defaultDensityBins
+		}
+		if cmd.Bins < 0 || cmd.Bins > maxDensityBins {
+			return errInvalidBins
+		}
+	case models.ModeCoverage:
+		if cmd.MinGap == 0 {
+			cmd.MinGap = defaultMinGap
+		}
+		if cmd.MinGap < 0 {
+			return errInvalidMinGap
+		}
+	case models.ModeCorrelate:
+		if _, err := expressionLanes(cmd.Expression); err != nil {
+			return err
+		}
+	default:
+		return errInvalidMode
+	}
+	return nil
 }
 
-// fetchFromCollection fetches data from a MongoDB collection and sends results to a channel
-func fetchFromCollection(ctx context.Context, c *websocket.Conn, socketMutex *sync.Mutex, config collectionConfig) ([]interface{}, error) {
-	start := time.Now()
-	client, err := db.GetDefaultMongoClient()
-	if err != nil {
-		writeErrorResponse(c, socketMutex, err)
-		return nil, err
-	}
-	collection := client.Database(config.dbName).Collection(config.collName)
-	// allowDiskUse := true
-	opts := options.Aggregate().SetAllowDiskUse(true)
+// sink receives the messages of a command, each written as {"type": msgKey, msgKey: msg}
+type sink interface {
+	// send writes a message of the command results
+	send(msgKey string, msg any) error
+	// notify writes a transient message, a queue position or an error, that a resumed command
+	// repeats instead of skipping
+	notify(msgKey string, msg any) error
+}
 
-	cursor, err := collection.Aggregate(ctx, config.filter, opts)
-	if err != nil {
-		writeErrorResponse(c, socketMutex, err)
-		return nil, err
-	}
-	log.Info().Str("command_id", config.commandID).Str("collection", config.collName).Int64("aggregation_time_in_millis", time.Since(start).Milliseconds()).Send()
-
-	defer cursor.Close(ctx) //nolint:errcheck
-	var results []interface{}
-	var resultsToSend []interface{}
-
-	isStartStatusSent := false
-	for cursor.Next(ctx) {
-		result := config.resultType
-		if err = cursor.Decode(result); err != nil {
-			_ = writeResponse(c, socketMutex, config.name, fiber.Map{"commandId": config.commandID, "status": "error"})
-			return results, err
-		}
-		// Ensure deep copy of result
-		var copiedResult interface{}
-		switch v := result.(type) {
-		case *models.Recording:
-			copied := *v // Create a new copy of the struct
-			copied.CommandID = config.commandID
-			copiedResult = &copied
-		case *models.Human:
-			copied := *v // Create a new copy of the struct
-			copied.CommandID = config.commandID
-			copiedResult = &copied
-		case *models.Vehicle:
-			copied := *v // Create a new copy of the struct
-			copied.CommandID = config.commandID
-			copiedResult = &copied
-		case *models.Event:
-			copied := *v // Create a new copy of the struct
-			copied.CommandID = config.commandID
-			copiedResult = &copied
-		default:
-			copiedResult = result
+// wsSink writes the messages to a websocket, one frame each
+type wsSink struct {
+	c     *websocket.Conn
+	mutex sync.Mutex
+}
 
-		}
-		resultsToSend = append(resultsToSend, copiedResult)
-		if len(resultsToSend) >= 200 {
-			if !isStartStatusSent {
-				isStartStatusSent = true
-				if err = writeResponse(c, socketMutex, config.name, fiber.Map{"commandId": config.commandID, "status": "start"}); err != nil {
-					return results, err
-				}
-				log.Info().Str("command_id", config.commandID).Str("collection", config.collName).Str("sent", "start").Send()
-			}
+func (s *wsSink) send(msgKey string, msg any) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.c.WriteJSON(fiber.Map{"type": msgKey, msgKey: msg})
+}
 
-			if err = writeResponse(c, socketMutex, config.name, resultsToSend); err != nil {
-				return results, err
-			}
-			log.Info().Str("command_id", config.commandID).Str("collection", config.collName).Str("sent", "data").Int("count", len(resultsToSend)).Send()
+func (s *wsSink) notify(msgKey string, msg any) error {
+	return s.send(msgKey, msg)
+}
 
-			results = append(results, resultsToSend...)
-			resultsToSend = nil
+func writeError(out sink, err error) {
+	_ = out.notify("error", err.Error())
+}
+
+// queuedObserver reports the queue position of the query of a lane while it waits for admission
+func queuedObserver(ctx context.Context, out sink, commandID string, laneName string) context.Context {
+	return admission.WithObserver(ctx, func(position int) {
+		_ = out.notify("status", models.QueuedStatus{
+			Status:    "queued",
+			CommandID: commandID,
+			Lane:      laneName,
+			Position:  position,
+		})
+	})
+}
+
+// sendLane writes the items of a lane in batches framed by start and done status messages, tag
+// stamps the command id on each item of a batch
+func sendLane[T any](out sink, laneName string, commandID string, items []T, tag func(item *T)) error {
+	if len(items) > 0 {
+		if err := out.send(laneName, models.LaneStatus{CommandID: commandID, Status: "start"}); err != nil {
+			return err
 		}
+		log.Info().Str("command_id", commandID).Str("lane", laneName).Str("sent", "start").Send()
 	}
-	if len(resultsToSend) > 0 {
-		if !isStartStatusSent {
-			if err = writeResponse(c, socketMutex, config.name, fiber.Map{"commandId": config.commandID, "status": "start"}); err != nil {
-				return results, err
-			}
-			log.Info().Str("command_id", config.commandID).Str("collection", config.collName).Str("sent", "start").Send()
+	for start := 0; start < len(items); start += maxBatchSize {
+		batch := slices.Clone(items[start:min(start+maxBatchSize, len(items))])
+		for i := range batch {
+			tag(&batch[i])
 		}
-		if err = writeResponse(c, socketMutex, config.name, resultsToSend); err != nil {
-			return results, err
+		if err := out.send(laneName, batch); err != nil {
+			return err
 		}
-		log.Info().Str("command_id", config.commandID).Str("collection", config.collName).Str("sent_last", "data").Int("count", len(resultsToSend)).Send()
-
-		results = append(results, resultsToSend...)
+		log.Info().Str("command_id", commandID).Str("lane", laneName).Str("sent", "data").Int("count", len(batch)).Send()
 	}
-
-	if err = cursor.Err(); err != nil {
-		writeErrorResponse(c, socketMutex, err)
-	}
-	if err == nil {
-		_ = writeResponse(c, socketMutex, config.name, fiber.Map{"commandId": config.commandID, "status": "done"})
-	}
-	return results, err
+	return out.send(laneName, models.LaneStatus{CommandID: commandID, Status: "done"})
 }
 
-func writeErrorResponse(c *websocket.Conn, socketMutex *sync.Mutex, err error) {
-	socketMutex.Lock()
-	defer socketMutex.Unlock()
-	_ = c.WriteJSON(fiber.Map{"type": "error", "error": err.Error()})
+// sendSegments writes the merged segments of a lane
+func sendSegments(out sink, laneName string, commandID string, segs []models.Segment) error {
+	return sendLane(out, laneName, commandID, segs, func(seg *models.Segment) { seg.CommandID = commandID })
 }
 
-func writeResponse(c *websocket.Conn, socketMutex *sync.Mutex, msgKey string, msg interface{}) error {
-	socketMutex.Lock()
-	defer socketMutex.Unlock()
-	err := c.WriteJSON(fiber.Map{"type": msgKey, msgKey: msg})
-	return err
+// sendBins writes the density bins of a lane
+func sendBins(out sink, laneName string, commandID string, bins []models.Bin) error {
+	return sendLane(out, laneName, commandID, bins, func(bin *models.Bin) { bin.CommandID = commandID })
 }
 
 // TimeLineWSHandler handles WebSocket connections for the timeline endpoint
 func TimeLineWSHandler(ctx context.Context, c *websocket.Conn) {
+	out := &wsSink{c: c}
+	if err := auth.CompleteWebSocket(c); err != nil {
+		log.Warn().Err(err).Msg("Websocket authentication failed")
+		writeError(out, err)
+		return
+	}
 	siteID, channelID, err := parseParamsSiteIDChannelIDFromWS(c)
 	if err != nil {
-		writeErrorResponse(c, &sync.Mutex{}, err)
+		writeError(out, err)
 		return
 	}
-	logger := log.With().Int("siteId", siteID).Int("channelId", channelID).Logger()
-	var socketMutex sync.Mutex
+	principal, authenticated := auth.FromConn(c)
+	client := clientKey(principal, authenticated, c.IP())
+	logger := log.With().Int("siteId", siteID).Int("channelId", channelID).Str("client", client).Logger()
 
-	var cancel context.CancelFunc
-	var ctx1 context.Context
+	// A new command replaces the running one, which is canceled and waited for so that its frames
+	// come first. The handler returns once the last command is done with the connection.
+	cancel := func() {}
+	var running sync.WaitGroup
+	defer func() {
+		cancel()
+		running.Wait()
+	}()
 
 	for {
 		var cmd models.Command
 		if err = c.ReadJSON(&cmd); err != nil {
 			logger.Error().Err(err).Msg("Failed to read websocket command")
-			writeErrorResponse(c, &socketMutex, errInvalidCommand)
+			writeError(out, errInvalidCommand)
 			break
 		}
 		logger.Info().Msg("command:" + fmt.Sprint(cmd))
 
-		if cancel != nil {
-			logger.Info().Msg("Canceling previous command:" + fmt.Sprint(cmd))
-			cancel()
-			time.Sleep(100 * time.Millisecond)
-			cancel = nil
-		}
+		cancel()
+		running.Wait()
 
-		ctx1, cancel = context.WithCancel(ctx)
-		defer cancel()
+		cmdCtx, cancelCmd := context.WithCancel(ctx)
+		cancel = cancelCmd
+		running.Add(1)
 		go func() {
-			writeResults(ctx1, cmd, c, &socketMutex, siteID, channelID, &logger)
-			if cancel != nil {
-				cancel()
-				cancel = nil
-			}
+			defer running.Done()
+			defer cancelCmd()
+			writeResults(cmdCtx, cmd, out, client, siteID, channelID, &logger)
 		}()
 	}
 }
 
-func writeResults(ctx context.Context, cmd models.Command, c *websocket.Conn, socketMutex *sync.Mutex, siteID int, channelID int, logger *zerolog.Logger) {
-	// logger.Info().Str("command_id", cmd.CommandID).Str("Entering", "deferred").Send()
-	// defer func() {
-	// 	logger.Info().Str("command_id", cmd.CommandID).Str("Exiting", "deferred").Send()
-	// }()
+func writeResults(ctx context.Context, cmd models.Command, out sink, client string, siteID int, channelID int, logger *zerolog.Logger) {
 	if cmd.DomainMax < cmd.DomainMin {
 		logger.Error().Err(errInvalidTimeRange)
-		writeErrorResponse(c, socketMutex, errInvalidTimeRange)
+		writeError(out, errInvalidTimeRange)
 		return
 	}
 
-	var maxTimeGapAllowedInmSec int64
-	domainMax := int64(cmd.DomainMax)
-	domainMin := int64(cmd.DomainMin)
-	diff := domainMax - domainMin
-	maxTimeGapAllowedInmSec = diff / 5000
-	if maxTimeGapAllowedInmSec <= 0 {
-		maxTimeGapAllowedInmSec = 100
+	if err := validateMode(&cmd); err != nil {
+		logger.Error().Err(err).Str("command_id", cmd.CommandID).Send()
+		writeError(out, err)
+		return
 	}
-	// switch {
-	// case diff < maxTimeGapAllowedInmSecForDay:
-	// 	maxTimeGapAllowedInmSec = 100
-	// case diff < maxTimeGapAllowedInmSecFor15Day:
-	// 	maxTimeGapAllowedInmSec = maxTimeGapAllowedInmSecFor10Second
-	// case diff < maxTimeGapAllowedInmSecForMonth:
-	// 	maxTimeGapAllowedInmSec = maxTimeGapAllowedInmSecForMinute
-	// case diff < maxTimeGapAllowedInmSecFor3Months:
-	// 	maxTimeGapAllowedInmSec = maxTimeGapAllowedInmSecFor12Minutes
-	// case diff < maxTimeGapAllowedInmSecFor6Months:
-	// 	maxTimeGapAllowedInmSec = maxTimeGapAllowedInmSecFor24Minutes
-	// default:
-	// 	maxTimeGapAllowedInmSec = maxTimeGapAllowedInmSecForHour
-	// }
-	logger.Info().Str("command_id", cmd.CommandID).Int64("max_time_gap_in_ms", maxTimeGapAllowedInmSec).Send()
 
-	matchSiteIDChannelIDStage := bson.D{
-		{
-			Key: "$match",
-			Value: bson.D{
-				{Key: "siteId", Value: siteID},
-				{Key: "channelId", Value: channelID},
-			},
-		},
-	}
-	matchStage := bson.D{
-		{
-			Key: "$match",
-			Value: bson.D{
-				{
-					Key: "$or",
-					Value: bson.A{
-						bson.D{
-							{
-								Key: "startTimestamp",
-								Value: bson.D{
-									{Key: "$gte", Value: domainMin},
-									{Key: "$lte", Value: domainMax},
-								},
-							},
-						},
-						bson.D{
-							{
-								Key: "endTimestamp",
-								Value: bson.D{
-									{Key: "$gte", Value: domainMin},
-									{Key: "$lte", Value: domainMax},
-								},
-							},
-						},
-						bson.D{
-							{
-								Key: "$and",
-								Value: bson.A{
-									bson.D{{Key: "startTimestamp", Value: bson.D{{Key: "$lte", Value: domainMin}}}},
-									bson.D{{Key: "endTimestamp", Value: bson.D{{Key: "$gte", Value: domainMax}}}},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-	sortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: "startTimestamp", Value: 1}}}}
-	effectiveEndTimestampAddFieldsStage := bson.D{
-		{
-			Key: "$addFields",
-			Value: bson.D{
-				{
-					Key: "effectiveEndTimestamp",
-					Value: bson.D{
-						{
-							Key: "$add",
-							Value: bson.A{
-								"$endTimestamp",
-								maxTimeGapAllowedInmSec,
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-	prevEffectiveEndTimestampSetWindowFieldsStage := bson.D{
-		{
-			Key: "$setWindowFields",
-			Value: bson.D{
-				{Key: "sortBy", Value: bson.D{{Key: "startTimestamp", Value: 1}}},
-				{
-					Key: "output",
-					Value: bson.D{
-						{
-							Key: "prevEffectiveEndTimestamp",
-							Value: bson.D{
-								{
-									Key: "$shift",
-									Value: bson.D{
-										{Key: "output", Value: "$effectiveEndTimestamp"},
-										{Key: "by", Value: -1},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-	boundarySetStage := bson.D{
-		{
-			Key: "$set",
-			Value: bson.D{
-				{
-					Key: "boundary",
-					Value: bson.D{
-						{
-							Key: "$sum",
-							Value: bson.D{
-								{
-									Key: "$cond",
-									Value: bson.A{
-										bson.D{
-											{
-												Key: "$or",
-												Value: bson.A{
-													bson.D{
-														{
-															Key: "$eq",
-															Value: bson.A{
-																"$prevEffectiveEndTimestamp",
-																bson.Null{},
-															},
-														},
-													},
-													bson.D{
-														{
-															Key: "$lt",
-															Value: bson.A{
-																"$prevEffectiveEndTimestamp",
-																"$startTimestamp",
-															},
-														},
-													},
-												},
-											},
-										},
-										1,
-										0,
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-	uniqueGroupIDSetWindowFieldStage := bson.D{
-		{
-			Key: "$setWindowFields",
-			Value: bson.D{
-				{Key: "sortBy", Value: bson.D{{Key: "startTimestamp", Value: 1}}},
-				{
-					Key: "output",
-					Value: bson.D{
-						{
-							Key: "groupId",
-							Value: bson.D{
-								{Key: "$sum", Value: "$boundary"},
-								{
-									Key: "window",
-									Value: bson.D{
-										{
-											Key: "documents",
-											Value: bson.A{
-												"unbounded",
-												"current",
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-	recalculateGroupstage := bson.D{
-		{
-			Key: "$group",
-			Value: bson.D{
-				{Key: "_id", Value: "$groupId"},
-				{Key: "startTimestamp", Value: bson.D{{Key: "$first", Value: "$startTimestamp"}}},
-				{Key: "endTimestamp", Value: bson.D{{Key: "$last", Value: "$endTimestamp"}}},
-				{Key: "objectCount", Value: bson.D{{Key: "$sum", Value: "$objectCount"}}},
-			},
-		},
+	domainMax := int64(cmd.DomainMax)
+	domainMin := int64(cmd.DomainMin)
+	if cmd.Mode == models.ModeCoverage {
+		writeCoverage(ctx, cmd, out, client, siteID, channelID, logger)
+		return
 	}
-	finalSortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: "startTimestamp", Value: 1}}}}
-	filter := bson.A{
-		matchStage,
-		sortStage,
-		effectiveEndTimestampAddFieldsStage,
-		prevEffectiveEndTimestampSetWindowFieldsStage,
-		boundarySetStage,
-		uniqueGroupIDSetWindowFieldStage,
-		recalculateGroupstage,
-		finalSortStage,
+	if cmd.Mode == models.ModeCorrelate {
+		writeCorrelation(ctx, cmd, out, client, siteID, channelID, logger)
+		return
 	}
-	filterWithSiteIDChannelID := bson.A{matchSiteIDChannelIDStage}
-	filterWithSiteIDChannelID = append(filterWithSiteIDChannelID, filter...)
-
-	collectionConfigs := []collectionConfig{
-		{"recordings", "ivms_30", fmt.Sprintf("vVideoClips_%d_%d", siteID, channelID), &models.Recording{}, filter, cmd.CommandID},
-		{"humans", "pvaDB", fmt.Sprintf("pva_HUMAN_%d_%d", siteID, channelID), &models.Human{}, filter, cmd.CommandID},
-		{"vehicles", "pvaDB", fmt.Sprintf("pva_VEHICLE_%d_%d", siteID, channelID), &models.Vehicle{}, filter, cmd.CommandID},
-		{"events", "dasDB", "dasEvents", &models.Event{}, filterWithSiteIDChannelID, cmd.CommandID},
+
+	lanes := db.TimelineLanes()
+	if err := acquireBudget(ctx, client, domainMax-domainMin, len(lanes)); err != nil {
+		if ctx.Err() == nil {
+			writeError(out, err)
+		}
+		return
 	}
+	maxTimeGapAllowedInmSec := mergeGap(domainMax - domainMin)
+	logger.Info().Str("command_id", cmd.CommandID).Int64("max_time_gap_in_ms", maxTimeGapAllowedInmSec).Send()
 
-	if err := writeResponse(c, socketMutex, "status", fiber.Map{
-		"status":    "start",
-		"command":   cmd,
-		"siteId":    siteID,
-		"channelId": channelID,
+	if err := out.send("status", models.CommandStatus{
+		Status:    "start",
+		Command:   cmd,
+		SiteID:    siteID,
+		ChannelID: channelID,
+		Counts:    map[string]int{},
 	}); err != nil {
-		logger.Error().Err(err).Msg("writeResponse error")
+		logger.Error().Err(err).Msg("send error")
 	}
 
 	var wg sync.WaitGroup
-	counts := make(map[string]int, len(collectionConfigs))
-	for _, config := range collectionConfigs {
+	var countsMutex sync.Mutex
+	counts := make(map[string]int, len(lanes))
+	for _, lane := range lanes {
 		wg.Add(1)
-		go func(config collectionConfig,
-		) {
+		go func(lane db.Lane) {
 			defer wg.Done()
 			start := time.Now()
 
-			results, err1 := fetchFromCollection(ctx, c, socketMutex, config)
+			laneCtx := queuedObserver(ctx, out, cmd.CommandID, lane.Name)
+			segs, err1 := laneSegments(laneCtx, lane, siteID, channelID, domainMin, domainMax, maxTimeGapAllowedInmSec)
+			if err1 != nil {
+				// A canceled command was replaced by the next one, its client expects no error
+				if ctx.Err() == nil {
+					writeError(out, err1)
+				}
+				logger.Error().Str("command_id", cmd.CommandID).Str("fetching", lane.Name).Err(err1).Send()
+				return
+			}
+			count := len(segs)
+			if cmd.Mode == models.ModeDensity {
+				bins := segments.Density(segs, uint64(domainMin), uint64(domainMax), cmd.Bins)
+				count = len(bins)
+				err1 = sendBins(out, lane.Name, cmd.CommandID, bins)
+			} else {
+				err1 = sendSegments(out, lane.Name, cmd.CommandID, segs)
+			}
 			if err1 != nil {
-				logger.Error().Str("command_id", cmd.CommandID).Str("fetching", config.name).Err(err1).Send()
+				logger.Error().Str("command_id", cmd.CommandID).Str("sending", lane.Name).Err(err1).Send()
 				return
 			}
-			counts[config.name] = len(results)
-			logger.Info().Str("command_id", cmd.CommandID).Str("fetched-sent", config.name).Int("count", len(results)).Int64("time_taken_in_millis", time.Since(start).Milliseconds()).Send()
-		}(config)
+			countsMutex.Lock()
+			counts[lane.Name] = count
+			countsMutex.Unlock()
+			logger.Info().Str("command_id", cmd.CommandID).Str("fetched-sent", lane.Name).Int("count", len(segs)).Int64("time_taken_in_millis", time.Since(start).Milliseconds()).Send()
+		}(lane)
 	}
 	wg.Wait()
 
-	if err := writeResponse(c, socketMutex, "status", fiber.Map{
-		"status":    "done",
-		"command":   cmd,
-		"siteId":    siteID,
-		"channelId": channelID,
-		"counts":    counts,
+	if err := out.send("status", models.CommandStatus{
+		Status:    "done",
+		Command:   cmd,
+		SiteID:    siteID,
+		ChannelID: channelID,
+		Counts:    counts,
 	}); err != nil {
-		logger.Error().Err(err).Msg("writeResponse error")
+		logger.Error().Err(err).Msg("send error")
 	}
 
 	logger.Info().Msg("Timeline data sent")
+
+	if ctx.Err() == nil {
+		prefetch(siteID, channelID, domainMin, domainMax)
+	}
+}
+
+// writeCoverage answers a coverage command with the recording coverage report of its range
+func writeCoverage(ctx context.Context, cmd models.Command, out sink, client string, siteID int, channelID int, logger *zerolog.Logger) {
+	domainMax := int64(cmd.DomainMax)
+	domainMin := int64(cmd.DomainMin)
+	if err := acquireBudget(ctx, client, domainMax-domainMin, 1); err != nil {
+		if ctx.Err() == nil {
+			writeError(out, err)
+		}
+		return
+	}
+	laneCtx := queuedObserver(ctx, out, cmd.CommandID, db.LaneRecordings)
+	report, err := coverageReport(laneCtx, siteID, channelID, domainMin, domainMax, cmd.MinGap)
+	if err != nil {
+		if ctx.Err() == nil {
+			writeError(out, err)
+		}
+		logger.Error().Str("command_id", cmd.CommandID).Err(err).Msg("coverage error")
+		return
+	}
+	report.CommandID = cmd.CommandID
+	if err = out.send("coverage", report); err != nil {
+		logger.Error().Err(err).Msg("send error")
+		return
+	}
+	logger.Info().Str("command_id", cmd.CommandID).Float64("coverage", report.Coverage).Int("gaps", len(report.Gaps)).Msg("Coverage sent")
+}
+
+// writeCorrelation answers a correlate command with the segments of its expression
+func writeCorrelation(ctx context.Context, cmd models.Command, out sink, client string, siteID int, channelID int, logger *zerolog.Logger) {
+	domainMax := int64(cmd.DomainMax)
+	domainMin := int64(cmd.DomainMin)
+	lanes, err := expressionLanes(cmd.Expression)
+	if err != nil {
+		writeError(out, err)
+		return
+	}
+	if err = acquireBudget(ctx, client, domainMax-domainMin, len(lanes)); err != nil {
+		if ctx.Err() == nil {
+			writeError(out, err)
+		}
+		return
+	}
+	queryCtx := queuedObserver(ctx, out, cmd.CommandID, correlationLane)
+	segs, err := correlate(queryCtx, cmd.Expression, lanes, siteID, channelID, domainMin, domainMax, mergeGap(domainMax-domainMin))
+	if err != nil {
+		if ctx.Err() == nil {
+			writeError(out, err)
+		}
+		logger.Error().Str("command_id", cmd.CommandID).Err(err).Msg("correlate error")
+		return
+	}
+	if err = sendSegments(out, correlationLane, cmd.CommandID, segs); err != nil {
+		logger.Error().Str("command_id", cmd.CommandID).Str("sending", correlationLane).Err(err).Send()
+		return
+	}
+	logger.Info().Str("command_id", cmd.CommandID).Int("count", len(segs)).Msg("Correlation sent")
 }
@@ -4,12 +4,13 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/vtpl1/cacheserver/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/ratelimit"
 )
 
 var (
@@ -30,6 +31,10 @@ func parseParamsSiteIDChannelIDFromWS(c *websocket.Conn) (int, int, error) {
 		return 0, 0, errInvalidChannelID
 	}
 
+	principal, _ := auth.FromConn(c)
+	if err = authorize(principal, siteID, channelID); err != nil {
+		return 0, 0, err
+	}
 	return siteID, channelID, nil
 }
 
@@ -45,109 +50,75 @@ func parseParamsSiteIDChannelID(c *fiber.Ctx) (int, int, error) {
 		return 0, 0, errInvalidChannelID
 	}
 
+	principal, _ := auth.FromCtx(c)
+	if err = authorize(principal, siteID, channelID); err != nil {
+		return 0, 0, err
+	}
 	return siteID, channelID, nil
 }
 
-// parseParams parses query parameters from the request context
-func parseParams(c *fiber.Ctx) (int, int, uint64, uint64, error) {
-	siteID, channelID, err := parseParamsSiteIDChannelID(c)
-	if err != nil {
-		return 0, 0, 0, 0, err
-	}
-
-	timeStamp, err := strconv.ParseUint(c.Params("timeStamp"), 10, 64)
-	if err != nil {
-		return 0, 0, 0, 0, errInvalidTimeStamp
-	}
-
-	timeStampEnd, err := strconv.ParseUint(c.Params("timeStampEnd"), 10, 64)
-	if err != nil {
-		return 0, 0, 0, 0, errInvalidTimeStampEnd
+// authorize checks the policy lets principal read a site and channel. Commands spanning several
+// channels have to authorize each of them.
+func authorize(principal auth.Principal, siteID int, channelID int) error {
+	if !policy.Allowed(principal, siteID, channelID) {
+		log.Warn().Str("subject", principal.Subject).Int("siteId", siteID).Int("channelId", channelID).Msg("Access denied")
+		return auth.ErrForbidden
 	}
-
-	return siteID, channelID, timeStamp, timeStampEnd, nil
+	return nil
 }
 
-func fetchRecordings(ctx context.Context, db *mongo.Database, collectionName string, filter bson.M, siteID, channelID int) ([]models.Recording, error) {
-	collection := db.Collection(collectionName)
-	cursor, err := collection.Find(ctx, filter)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx) //nolint:errcheck
-
-	var results []models.Recording
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
+// clientKey identifies the client of a request for rate limiting, by subject when authenticated
+func clientKey(principal auth.Principal, authenticated bool, ip string) string {
+	if authenticated {
+		return principal.Subject
 	}
-
-	// Update metadata for each result
-	for i := range results {
-		results[i].SiteID = siteID
-		results[i].ChannelID = channelID
-	}
-	return results, nil
+	return "ip:" + ip
 }
 
-func fetchHumans(ctx context.Context, db *mongo.Database, collectionName string, filter bson.M, siteID, channelID int) ([]models.Human, error) {
-	collection := db.Collection(collectionName)
-	cursor, err := collection.Find(ctx, filter)
+// acquireBudget charges a query of span milliseconds on lanes to client
+func acquireBudget(ctx context.Context, client string, span int64, lanes int) error {
+	if limiter == nil {
+		return nil
+	}
+	cost := limiter.Cost(time.Duration(span)*time.Millisecond, lanes)
+	waited, err := limiter.Acquire(ctx, client, cost)
 	if err != nil {
-		return nil, err
+		log.Warn().Err(err).Str("client", client).Int("cost", cost).Msg("Query rejected")
+		return err
 	}
-	defer cursor.Close(ctx) //nolint:errcheck
-
-	var results []models.Human
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
+	if waited > 0 {
+		log.Info().Str("client", client).Int("cost", cost).Dur("waited", waited).Msg("Query was rate limited")
 	}
+	return nil
+}
 
-	// Update metadata for each result
-	for i := range results {
-		results[i].SiteID = siteID
-		results[i].ChannelID = channelID
+// statusOf maps a request error to its HTTP status
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		return fiber.StatusForbidden
+	case errors.Is(err, ratelimit.ErrRateLimited), errors.Is(err, ratelimit.ErrBudgetExceeded):
+		return fiber.StatusTooManyRequests
 	}
-	return results, nil
+	return fiber.StatusBadRequest
 }
 
-func fetchVehicles(ctx context.Context, db *mongo.Database, collectionName string, filter bson.M, siteID, channelID int) ([]models.Vehicle, error) {
-	collection := db.Collection(collectionName)
-	cursor, err := collection.Find(ctx, filter)
+// parseParams parses query parameters from the request context
+func parseParams(c *fiber.Ctx) (int, int, uint64, uint64, error) {
+	siteID, channelID, err := parseParamsSiteIDChannelID(c)
 	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx) //nolint:errcheck
-
-	var results []models.Vehicle
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
-	}
-
-	// Update metadata for each result
-	for i := range results {
-		results[i].SiteID = siteID
-		results[i].ChannelID = channelID
+		return 0, 0, 0, 0, err
 	}
-	return results, nil
-}
 
-func fetchEvents(ctx context.Context, db *mongo.Database, collectionName string, filter bson.M, siteID, channelID int) ([]models.Event, error) {
-	collection := db.Collection(collectionName)
-	cursor, err := collection.Find(ctx, filter)
+	timeStamp, err := strconv.ParseUint(c.Params("timeStamp"), 10, 64)
 	if err != nil {
-		return nil, err
+		return 0, 0, 0, 0, errInvalidTimeStamp
 	}
-	defer cursor.Close(ctx) //nolint:errcheck
 
-	var results []models.Event
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
+	timeStampEnd, err := strconv.ParseUint(c.Params("timeStampEnd"), 10, 64)
+	if err != nil {
+		return 0, 0, 0, 0, errInvalidTimeStampEnd
 	}
 
-	// Update metadata for each result
-	for i := range results {
-		results[i].SiteID = siteID
-		results[i].ChannelID = channelID
-	}
-	return results, nil
+	return siteID, channelID, timeStamp, timeStampEnd, nil
 }
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKeys authenticates static API keys, mapping each key to its subject
+type APIKeys map[string]string
+
+// LoadAPIKeys reads a file with one "<key> <subject>" pair per line. Blank lines and lines
+// starting with # are skipped, a key without subject or listed twice fails with its line.
+func LoadAPIKeys(path string) (APIKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	keys := make(APIKeys)
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, subject, _ := strings.Cut(line, " ")
+		subject = strings.TrimSpace(subject)
+		if subject == "" {
+			return nil, fmt.Errorf("%w: %s line %d", ErrMissingSubject, path, number)
+		}
+		if _, ok := keys[key]; ok {
+			return nil, fmt.Errorf("%w: %s line %d", ErrDuplicateKey, path, number)
+		}
+		keys[key] = subject
+	}
+	return keys, scanner.Err()
+}
+
+// Authenticate implements Authenticator
+func (k APIKeys) Authenticate(token string) (Principal, error) {
+	for key, subject := range k {
+		// Compare every key in constant time so timing does not reveal key prefixes
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			return Principal{Subject: subject, Method: MethodAPIKey}, nil
+		}
+	}
+	return Principal{}, ErrInvalidToken
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// MethodAPIKey authenticates with a static API key
+	MethodAPIKey = "api-key"
+	// MethodHMAC authenticates with a token signed by a shared secret
+	MethodHMAC = "hmac"
+	// MethodJWT authenticates with a JWT verified against a key set
+	MethodJWT = "jwt"
+
+	// TokenQueryParam carries the token of clients unable to set headers, such as browser websockets
+	TokenQueryParam = "token"
+	// APIKeyHeader carries a static API key
+	APIKeyHeader = "X-API-Key"
+
+	localsPrincipal = "principal"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject string
+	Method  string
+	// Claims holds the token claims, it is empty for API keys
+	Claims map[string]any
+}
+
+// Authenticator verifies presented credentials
+type Authenticator interface {
+	Authenticate(token string) (Principal, error)
+}
+
+// Chain accepts credentials accepted by any of its authenticators, tried in order
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(token string) (Principal, error) {
+	if token == "" {
+		return Principal{}, ErrMissingToken
+	}
+	err := ErrInvalidToken
+	for _, a := range c {
+		principal, authErr := a.Authenticate(token)
+		if authErr == nil {
+			return principal, nil
+		}
+		if errors.Is(authErr, ErrExpiredToken) {
+			err = authErr
+		}
+	}
+	return Principal{}, err
+}
+
+// FromCtx returns the principal of a request, false when authentication is disabled
+func FromCtx(c *fiber.Ctx) (Principal, bool) {
+	principal, ok := c.Locals(localsPrincipal).(Principal)
+	return principal, ok
+}
+
+// tokenOf returns the credentials of a request from the Authorization bearer, the API key header
+// or the token query parameter
+func tokenOf(c *fiber.Ctx) string {
+	if bearer, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	if key := c.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	return c.Query(TokenQueryParam)
+}
+
+// New returns a middleware rejecting requests without valid credentials with 401 and storing
+// the principal of the others
+func New(a Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := a.Authenticate(tokenOf(c))
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		c.Locals(localsPrincipal, principal)
+		return c.Next()
+	}
+}
@@ -0,0 +1,216 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	fiberws "github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/auth"
+)
+
+func TestAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("# operators\nkey-1 alice\n\nkey-2 nvr-service\n"), 0o600))
+	keys, err := auth.LoadAPIKeys(path)
+	require.NoError(t, err)
+
+	principal, err := keys.Authenticate("key-2")
+	require.NoError(t, err)
+	assert.Equal(t, auth.Principal{Subject: "nvr-service", Method: auth.MethodAPIKey}, principal)
+	_, err = keys.Authenticate("key-3")
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestAPIKeysInvalidFile(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		err     error
+		line    string
+	}{
+		{"key-1 alice\nkey-2\n", auth.ErrMissingSubject, "line 2"},
+		{"key-1 alice\n# again\nkey-1 bob\n", auth.ErrDuplicateKey, "line 3"},
+	} {
+		path := filepath.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+		_, err := auth.LoadAPIKeys(path)
+		require.ErrorIs(t, err, tc.err)
+		assert.ErrorContains(t, err, tc.line)
+	}
+}
+
+func TestHMACTokens(t *testing.T) {
+	tokens := auth.NewHMACTokens([]byte("secret"))
+	token, err := tokens.Issue("alice", map[string]any{"role": "operator"}, time.Hour)
+	require.NoError(t, err)
+
+	principal, err := tokens.Authenticate(token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", principal.Subject)
+	assert.Equal(t, "operator", principal.Claims["role"])
+
+	_, err = auth.NewHMACTokens([]byte("other")).Authenticate(token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	_, err = tokens.Authenticate(token[1:])
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	expired, err := tokens.Issue("alice", map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}, 0)
+	require.NoError(t, err)
+	_, err = tokens.Authenticate(expired)
+	assert.ErrorIs(t, err, auth.ErrExpiredToken)
+}
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	t.Helper()
+	set := map[string]any{"keys": []map[string]string{{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestJWTVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	verifier, err := auth.LoadJWKS(writeJWKS(t, "k1", &key.PublicKey), auth.JWTOptions{Issuer: "vms", Audience: "cacheserver"})
+	require.NoError(t, err)
+
+	sign := func(kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := jwt.MapClaims{"sub": "alice", "iss": "vms", "aud": "cacheserver", "exp": time.Now().Add(time.Hour).Unix()}
+
+	principal, err := verifier.Authenticate(sign("k1", valid))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", principal.Subject)
+	assert.Equal(t, auth.MethodJWT, principal.Method)
+
+	_, err = verifier.Authenticate(sign("k2", valid))
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	wrongAudience := jwt.MapClaims{"sub": "alice", "iss": "vms", "aud": "other"}
+	_, err = verifier.Authenticate(sign("k1", wrongAudience))
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	expired := jwt.MapClaims{"sub": "alice", "iss": "vms", "aud": "cacheserver", "exp": time.Now().Add(-time.Hour).Unix()}
+	_, err = verifier.Authenticate(sign("k1", expired))
+	assert.ErrorIs(t, err, auth.ErrExpiredToken)
+}
+
+func TestMiddleware(t *testing.T) {
+	app := fiber.New()
+	app.Use(auth.New(auth.Chain{auth.APIKeys{"key-1": "alice"}}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		principal, _ := auth.FromCtx(c)
+		return c.SendString(principal.Subject)
+	})
+
+	for name, setup := range map[string]func(r *http.Request){
+		"bearer": func(r *http.Request) { r.Header.Set(fiber.HeaderAuthorization, "Bearer key-1") },
+		"header": func(r *http.Request) { r.Header.Set(auth.APIKeyHeader, "key-1") },
+		"query":  nil,
+	} {
+		req := httptest.NewRequest(fiber.MethodGet, "/?"+auth.TokenQueryParam+"=key-1", nil)
+		if setup != nil {
+			req = httptest.NewRequest(fiber.MethodGet, "/", nil)
+			setup(req)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err, name)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode, name)
+		_ = resp.Body.Close()
+	}
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	req.Header.Set(auth.APIKeyHeader, "key-2")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func startWebSocketServer(t *testing.T, timeout time.Duration) string {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use("/ws", auth.WebSocket(auth.APIKeys{"key-1": "alice"}, timeout))
+	app.Get("/ws", fiberws.New(func(c *fiberws.Conn) {
+		if err := auth.CompleteWebSocket(c); err != nil {
+			_ = c.WriteJSON(fiber.Map{"type": "error", "error": err.Error()})
+			return
+		}
+		principal, _ := auth.FromConn(c)
+		_ = c.WriteJSON(fiber.Map{"type": "hello", "hello": principal.Subject})
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.Listener(listener) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+	return "ws://" + listener.Addr().String() + "/ws"
+}
+
+func TestWebSocket(t *testing.T) {
+	url := startWebSocketServer(t, time.Second)
+	read := func(conn *websocket.Conn) map[string]any {
+		var msg map[string]any
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url+"?token=key-1", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "alice", read(conn)["hello"])
+	_ = conn.Close()
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?token=key-2", nil)
+	require.Error(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	conn, resp, err = websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.NoError(t, conn.WriteJSON(map[string]string{"token": "key-1"}))
+	assert.Equal(t, "alice", read(conn)["hello"])
+	_ = conn.Close()
+
+	conn, resp, err = websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.NoError(t, conn.WriteJSON(map[string]string{"token": "key-2"}))
+	assert.Equal(t, auth.ErrInvalidToken.Error(), read(conn)["error"])
+	_ = conn.Close()
+}
+
+func TestWebSocketRequiresCredentialsOnUpgrade(t *testing.T) {
+	url := startWebSocketServer(t, 0)
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Error(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,28 @@
+// Package auth exports error types for auth package.
+package auth
+
+import "errors"
+
+// ErrMissingToken is returned when a request carries no credentials
+var ErrMissingToken = errors.New("missing credentials")
+
+// ErrInvalidToken is returned when no authenticator accepts the presented credentials
+var ErrInvalidToken = errors.New("invalid credentials")
+
+// ErrExpiredToken is returned when a token is past its expiry
+var ErrExpiredToken = errors.New("expired token")
+
+// ErrInvalidKeySet is returned when a JWKS file holds no usable key
+var ErrInvalidKeySet = errors.New("invalid key set")
+
+// ErrForbidden is returned when a principal may not read a site and channel
+var ErrForbidden = errors.New("forbidden")
+
+// ErrInvalidScope is returned when a policy grant is not "<siteId>/<channelId>" with optional * wildcards
+var ErrInvalidScope = errors.New("invalid scope")
+
+// ErrMissingSubject is returned for an API key file line without the subject of its key
+var ErrMissingSubject = errors.New("missing subject")
+
+// ErrDuplicateKey is returned for an API key listed twice
+var ErrDuplicateKey = errors.New("duplicate key")
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying principal
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of a gRPC call, false when authentication is disabled
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// tokenOfMetadata returns the credentials of a gRPC call from the authorization bearer or the
+// API key metadata
+func tokenOfMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if bearer, ok := strings.CutPrefix(value, "Bearer "); ok {
+			return strings.TrimSpace(bearer)
+		}
+	}
+	if keys := md.Get(APIKeyHeader); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+func authenticateCall(ctx context.Context, a Authenticator) (context.Context, error) {
+	principal, err := a.Authenticate(tokenOfMetadata(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return NewContext(ctx, principal), nil
+}
+
+// UnaryServerInterceptor rejects unary calls without valid credentials with UNAUTHENTICATED and
+// stores the principal of the others in their context
+func UnaryServerInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticateCall(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the UnaryServerInterceptor of streaming calls
+func StreamServerInterceptor(a Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateCall(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticatedStream is a server stream whose context carries the principal
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context //nolint:containedctx
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Policy decides which sites and channels a principal may read. Grants are written
+// "<siteId>/<channelId>", "<siteId>/*" or "*" and come from the subject of the principal and
+// from a claim of its token.
+type Policy struct {
+	subjects map[string][]scope
+	claim    string
+}
+
+// PolicyFile is the JSON form of a Policy
+type PolicyFile struct {
+	// Claim names the token claim listing grants, as an array or a space separated string
+	Claim string `json:"claim"`
+	// Subjects maps API key subjects and token subjects to their grants
+	Subjects map[string][]string `json:"subjects"`
+}
+
+type scope struct {
+	siteID     int
+	channelID  int
+	anySite    bool
+	anyChannel bool
+}
+
+// NewPolicy parses the grants of a policy file
+func NewPolicy(file PolicyFile) (*Policy, error) {
+	p := &Policy{subjects: make(map[string][]scope, len(file.Subjects)), claim: file.Claim}
+	for subject, grants := range file.Subjects {
+		for _, grant := range grants {
+			s, err := parseScope(grant)
+			if err != nil {
+				return nil, fmt.Errorf("subject %q: %w", subject, err)
+			}
+			p.subjects[subject] = append(p.subjects[subject], s)
+		}
+	}
+	return p, nil
+}
+
+// LoadPolicy reads a JSON policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file PolicyFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return NewPolicy(file)
+}
+
+// Allowed reports whether principal may read a site and channel. A nil policy allows everything.
+func (p *Policy) Allowed(principal Principal, siteID int, channelID int) bool {
+	if p == nil {
+		return true
+	}
+	for _, s := range p.subjects[principal.Subject] {
+		if s.covers(siteID, channelID) {
+			return true
+		}
+	}
+	for _, grant := range p.claimGrants(principal) {
+		// Malformed grants in tokens are ignored rather than failing the request
+		if s, err := parseScope(grant); err == nil && s.covers(siteID, channelID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) claimGrants(principal Principal) []string {
+	if p.claim == "" {
+		return nil
+	}
+	switch value := principal.Claims[p.claim].(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		grants := make([]string, 0, len(value))
+		for _, v := range value {
+			if grant, ok := v.(string); ok {
+				grants = append(grants, grant)
+			}
+		}
+		return grants
+	}
+	return nil
+}
+
+func parseScope(grant string) (scope, error) {
+	if grant == "*" {
+		return scope{anySite: true, anyChannel: true}, nil
+	}
+	site, channel, ok := strings.Cut(grant, "/")
+	if !ok {
+		return scope{}, fmt.Errorf("%w: %q", ErrInvalidScope, grant)
+	}
+	var s scope
+	var err error
+	if s.siteID, err = strconv.Atoi(site); err != nil {
+		return scope{}, fmt.Errorf("%w: %q", ErrInvalidScope, grant)
+	}
+	if channel == "*" {
+		s.anyChannel = true
+	} else if s.channelID, err = strconv.Atoi(channel); err != nil {
+		return scope{}, fmt.Errorf("%w: %q", ErrInvalidScope, grant)
+	}
+	return s, nil
+}
+
+func (s scope) covers(siteID int, channelID int) bool {
+	return (s.anySite || s.siteID == siteID) && (s.anyChannel || s.channelID == channelID)
+}
@@ -0,0 +1,40 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/auth"
+)
+
+func TestPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"claim": "cameras",
+		"subjects": {"alice": ["1/2", "3/*"], "admin": ["*"]}
+	}`), 0o600))
+	policy, err := auth.LoadPolicy(path)
+	require.NoError(t, err)
+
+	alice := auth.Principal{Subject: "alice"}
+	assert.True(t, policy.Allowed(alice, 1, 2))
+	assert.False(t, policy.Allowed(alice, 1, 3))
+	assert.True(t, policy.Allowed(alice, 3, 7))
+	assert.True(t, policy.Allowed(auth.Principal{Subject: "admin"}, 9, 9))
+
+	bob := auth.Principal{Subject: "bob", Claims: map[string]any{"cameras": []any{"4/5", "bad"}}}
+	assert.True(t, policy.Allowed(bob, 4, 5))
+	assert.False(t, policy.Allowed(bob, 4, 6))
+	carol := auth.Principal{Subject: "carol", Claims: map[string]any{"cameras": "6/1 6/2"}}
+	assert.True(t, policy.Allowed(carol, 6, 2))
+	assert.False(t, policy.Allowed(auth.Principal{Subject: "dave"}, 1, 2))
+
+	var none *auth.Policy
+	assert.True(t, none.Allowed(auth.Principal{}, 1, 2))
+
+	_, err = auth.NewPolicy(auth.PolicyFile{Subjects: map[string][]string{"alice": {"1-2"}}})
+	assert.ErrorIs(t, err, auth.ErrInvalidScope)
+}
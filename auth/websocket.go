@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const localsPending = "authPending"
+
+// pending is stored on upgrades deferring authentication to the first message
+type pending struct {
+	authenticator Authenticator
+	timeout       time.Duration
+}
+
+// FirstMessage is the message authenticating a websocket opened without credentials
+type FirstMessage struct {
+	Token string `json:"token"`
+}
+
+// WebSocket returns a middleware for websocket upgrades. Upgrades with invalid credentials are
+// rejected with 401. Upgrades without credentials are rejected too unless firstMessageTimeout is
+// positive, the connection then has to send {"token": "..."} as its first message within it.
+func WebSocket(a Authenticator, firstMessageTimeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := tokenOf(c)
+		if token == "" && firstMessageTimeout > 0 {
+			c.Locals(localsPending, pending{authenticator: a, timeout: firstMessageTimeout})
+			return c.Next()
+		}
+		principal, err := a.Authenticate(token)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		c.Locals(localsPrincipal, principal)
+		return c.Next()
+	}
+}
+
+// CompleteWebSocket authenticates a connection upgraded without credentials with its first message.
+// It returns nil at once for connections authenticated at the upgrade or when authentication is disabled.
+func CompleteWebSocket(c *websocket.Conn) error {
+	p, ok := c.Locals(localsPending).(pending)
+	if !ok {
+		return nil
+	}
+	if err := c.SetReadDeadline(time.Now().Add(p.timeout)); err != nil {
+		return err
+	}
+	var msg FirstMessage
+	if err := c.ReadJSON(&msg); err != nil {
+		return ErrMissingToken
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	principal, err := p.authenticator.Authenticate(msg.Token)
+	if err != nil {
+		return err
+	}
+	c.Locals(localsPending, nil)
+	c.Locals(localsPrincipal, principal)
+	return nil
+}
+
+// FromConn returns the principal of a websocket connection, false when authentication is disabled
+func FromConn(c *websocket.Conn) (Principal, bool) {
+	principal, ok := c.Locals(localsPrincipal).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+	"github.com/vtpl1/cacheserver/auth"
+)
+
+var (
+	errNoHMACSecret      = errors.New("--hmac-secret-file is required to issue tokens")
+	errPolicyWithoutAuth = errors.New("--authorization-file needs an --api-key-file, --hmac-secret-file or --jwks-file")
+)
+
+// newAuthenticator builds the authenticator of the configured credential sources, nil when
+// none is configured and the endpoints stay open.
+func newAuthenticator(cmd *cli.Command) (auth.Authenticator, error) {
+	var chain auth.Chain
+	if path := cmd.String("api-key-file"); path != "" {
+		keys, err := auth.LoadAPIKeys(path)
+		if err != nil {
+			return nil, fmt.Errorf("api keys: %w", err)
+		}
+		chain = append(chain, keys)
+	}
+	if path := cmd.String("hmac-secret-file"); path != "" {
+		tokens, err := auth.LoadHMACTokens(path)
+		if err != nil {
+			return nil, fmt.Errorf("hmac secret: %w", err)
+		}
+		chain = append(chain, tokens)
+	}
+	if path := cmd.String("jwks-file"); path != "" {
+		verifier, err := auth.LoadJWKS(path, auth.JWTOptions{
+			Issuer:   cmd.String("jwt-issuer"),
+			Audience: cmd.String("jwt-audience"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("jwks: %w", err)
+		}
+		chain = append(chain, verifier)
+	}
+	if len(chain) == 0 {
+		return nil, nil //nolint:nilnil // no authenticator means authentication is disabled
+	}
+	return chain, nil
+}
+
+// tokenCommand issues HMAC signed tokens for operators and services.
+func tokenCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "token",
+		Usage: "Issue a token signed with the --hmac-secret-file secret",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "subject",
+				Usage:    "The subject the token authenticates",
+				Required: true,
+			},
+			&cli.DurationFlag{
+				Name:  "ttl",
+				Usage: "How long the token is valid, 0 never expires",
+			},
+		},
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			path := cmd.String("hmac-secret-file")
+			if path == "" {
+				return errNoHMACSecret
+			}
+			tokens, err := auth.LoadHMACTokens(path)
+			if err != nil {
+				return err
+			}
+			token, err := tokens.Issue(cmd.String("subject"), nil, cmd.Duration("ttl"))
+			if err != nil {
+				return err
+			}
+			fmt.Println(token)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Backend stores cached values, possibly shared by several replicas
+type Backend interface {
+	// Get returns the value of key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value of key for ttl, 0 keeps it until it is evicted
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+	// TTL returns the time key is still kept for, 0 when it has no expiry and false when it is missing
+	TTL(ctx context.Context, key string) (time.Duration, bool, error)
+}
+
+// Tier is a backend consulted before computing a value, computed values are stored in it for TTL
+type Tier struct {
+	Backend Backend
+	TTL     time.Duration
+}
+
+// Layered returns a Func consulting the tiers in order before calling f. Values found in a later
+// tier or computed by f are stored in the tiers missing them. A failing backend is logged and
+// skipped, it never fails the computation.
+func Layered(f Func, tiers ...Tier) Func {
+	for i := len(tiers) - 1; i >= 0; i-- {
+		f = readThrough(tiers[i], f)
+	}
+	return f
+}
+
+func readThrough(tier Tier, f Func) Func {
+	return func(ctx context.Context, key string) ([]byte, error) {
+		value, ok, err := tier.Backend.Get(ctx, key)
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Failed to read cache backend")
+		}
+		if ok {
+			return value, nil
+		}
+		value, err = f(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err = tier.Backend.Set(ctx, key, value, tier.TTL); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Failed to write cache backend")
+		}
+		return value, nil
+	}
+}
+
+// MemoryBackend is a Backend local to the process
+type MemoryBackend struct {
+	maxEntries int
+
+	lock    sync.Mutex
+	items   map[string]*memoryItem
+	inserts *list.List
+}
+
+type memoryItem struct {
+	value     []byte
+	expiresAt time.Time
+	element   *list.Element
+}
+
+// NewMemoryBackend creates a memory backend. When maxEntries is positive the oldest inserted
+// values are evicted beyond it.
+func NewMemoryBackend(maxEntries int) *MemoryBackend {
+	return &MemoryBackend{maxEntries: maxEntries, items: make(map[string]*memoryItem), inserts: list.New()}
+}
+
+// Get implements Backend
+func (m *MemoryBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, ok := m.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return item.value, true, nil
+}
+
+// Set implements Backend
+func (m *MemoryBackend) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.remove(key)
+	item := &memoryItem{value: value, element: m.inserts.PushBack(key)}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+	m.items[key] = item
+	if m.maxEntries > 0 && m.inserts.Len() > m.maxEntries {
+		m.remove(m.inserts.Front().Value.(string)) //nolint:forcetypeassert
+	}
+	return nil
+}
+
+// Delete implements Backend
+func (m *MemoryBackend) Delete(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.remove(key)
+	return nil
+}
+
+// TTL implements Backend
+func (m *MemoryBackend) TTL(_ context.Context, key string) (time.Duration, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, ok := m.lookup(key)
+	if !ok {
+		return 0, false, nil
+	}
+	if item.expiresAt.IsZero() {
+		return 0, true, nil
+	}
+	return time.Until(item.expiresAt), true, nil
+}
+
+// lookup returns the item of key, removing it when expired
+func (m *MemoryBackend) lookup(key string) (*memoryItem, bool) {
+	item, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !item.expiresAt.IsZero() && !time.Now().Before(item.expiresAt) {
+		m.remove(key)
+		return nil, false
+	}
+	return item, true
+}
+
+func (m *MemoryBackend) remove(key string) {
+	if item, ok := m.items[key]; ok {
+		m.inserts.Remove(item.element)
+		delete(m.items, key)
+	}
+}
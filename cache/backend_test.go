@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendExpiry(t *testing.T) {
+	backend := NewMemoryBackend(0)
+	ctx := context.Background()
+	if err := backend.Set(ctx, "short", []byte("value"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Set(ctx, "forever", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := backend.Get(ctx, "short"); ok {
+		t.Fatal("expected the expired value to be a miss")
+	}
+	if ttl, ok, err := backend.TTL(ctx, "forever"); err != nil || !ok || ttl != 0 {
+		t.Fatalf("unexpected ttl %v %v %v", ttl, ok, err)
+	}
+	if err := backend.Delete(ctx, "forever"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := backend.TTL(ctx, "forever"); ok {
+		t.Fatal("expected the deleted value to be missing")
+	}
+}
+
+func TestMemoryBackendEviction(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := backend.Set(ctx, key, []byte(key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := backend.Get(ctx, "a"); ok {
+		t.Fatal("expected the oldest value to be evicted")
+	}
+	if value, ok, _ := backend.Get(ctx, "c"); !ok || string(value) != "c" {
+		t.Fatalf("unexpected %q %v", value, ok)
+	}
+}
+
+// failingBackend fails every call, it must not fail the computation
+type failingBackend struct{}
+
+func (failingBackend) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("down")
+}
+
+func (failingBackend) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("down")
+}
+
+func (failingBackend) Delete(context.Context, string) error { return errors.New("down") }
+
+func (failingBackend) TTL(context.Context, string) (time.Duration, bool, error) {
+	return 0, false, errors.New("down")
+}
+
+func TestLayered(t *testing.T) {
+	near := NewMemoryBackend(0)
+	far := NewMemoryBackend(0)
+	ctx := context.Background()
+	if err := far.Set(ctx, "shared", []byte("from far"), 0); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	f := Layered(func(_ context.Context, key string) ([]byte, error) {
+		calls++
+		if key == "fails" {
+			return nil, errors.New("failed")
+		}
+		return []byte(key), nil
+	}, Tier{Backend: failingBackend{}}, Tier{Backend: near, TTL: time.Hour}, Tier{Backend: far})
+
+	if value, err := f(ctx, "shared"); err != nil || string(value) != "from far" {
+		t.Fatalf("unexpected %q %v", value, err)
+	}
+	if value, ok, _ := near.Get(ctx, "shared"); !ok || string(value) != "from far" {
+		t.Fatal("expected the value of the far tier to be copied to the near tier")
+	}
+	for i := 0; i < 2; i++ {
+		if value, err := f(ctx, "a"); err != nil || string(value) != "a" {
+			t.Fatalf("unexpected %q %v", value, err)
+		}
+		if _, err := f(ctx, "fails"); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected stored values to be reused and failures to be retried, got %d calls", calls)
+	}
+	if ttl, ok, _ := near.TTL(ctx, "a"); !ok || ttl <= 0 {
+		t.Fatalf("expected the tier ttl to be applied, got %v %v", ttl, ok)
+	}
+}
@@ -1,72 +1,142 @@
+// Package cache memoizes expensive results by key, computing each key once even under concurrent requests
 package cache
 
 import (
+	"container/list"
+	"context"
 	"errors"
 )
 
+var errRecovered = errors.New("recovered in f")
+
 type Cache struct {
-	requests chan request
-	// cache map[string]*entry
-	// sync.Mutex
+	requests   chan request
+	forgets    chan forget
+	leaves     chan forget
+	maxEntries int
 }
 
 type request struct {
+	ctx      context.Context
 	key      string
 	response chan result
+	joined   chan *entry
 }
 
-type resultValue []byte
-
 type result struct {
-	value resultValue
+	value []byte
 	err   error
 }
 
+// forget asks the server to drop a failed entry, or a caller to leave an entry
+type forget struct {
+	key   string
+	entry *entry
+}
+
 type entry struct {
-	res   result
-	ready chan struct{}
+	res     result
+	ready   chan struct{}
+	element *list.Element
+	// refs counts the callers waiting for a pending computation, it is canceled without any
+	refs   int
+	cancel context.CancelFunc
 }
 
-type Func func(key string) (resultValue, error)
+// Func computes the value of a key
+type Func func(ctx context.Context, key string) ([]byte, error)
 
-func NewCache(f Func) *Cache {
-	cache := &Cache{requests: make(chan request)}
+// NewCache creates a cache computing missing keys with f. When maxEntries is positive the least
+// recently used entries are evicted beyond it.
+func NewCache(f Func, maxEntries int) *Cache {
+	cache := &Cache{requests: make(chan request), forgets: make(chan forget), leaves: make(chan forget), maxEntries: maxEntries}
 	go cache.server(f)
 	return cache
 }
 
-func (c *Cache) Get(key string) (resultValue, error) {
-	response := make(chan result)
-	c.requests <- request{key, response}
-	res := <-response
-	return res.value, res.err
+// Get returns the value of key, computing it when missing. The computation carries the values of
+// the context of the first caller and is canceled once every caller waiting for it is gone.
+// Failed computations are not kept, so the next Get tries again.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	response := make(chan result, 1)
+	joined := make(chan *entry, 1)
+	c.requests <- request{ctx, key, response, joined}
+	e := <-joined
+	select {
+	case res := <-response:
+		return res.value, res.err
+	case <-ctx.Done():
+		c.leaves <- forget{key, e}
+		return nil, ctx.Err()
+	}
 }
 
 func (c *Cache) server(f Func) {
 	cache := make(map[string]*entry)
-	for req := range c.requests {
-		e, ok := cache[req.key]
-		if !ok {
-			e = &entry{ready: make(chan struct{})}
-			// fmt.Printf("Adding to list %s\n", req.key)
-			cache[req.key] = e
-			go e.call(f, req.key)
-
-		} else {
-			// fmt.Printf("In the list %s\n", req.key)
+	recent := list.New()
+	for {
+		select {
+		case req := <-c.requests:
+			e, ok := cache[req.key]
+			if !ok {
+				ctx, cancel := context.WithCancel(context.WithoutCancel(req.ctx))
+				e = &entry{ready: make(chan struct{}), cancel: cancel}
+				e.element = recent.PushFront(req.key)
+				cache[req.key] = e
+				go e.call(ctx, f, req.key, c.forgets)
+			} else {
+				recent.MoveToFront(e.element)
+			}
+			select {
+			case <-e.ready:
+			default:
+				e.refs++
+			}
+			req.joined <- e
+			go e.deliver(req.response)
+			if c.maxEntries > 0 && recent.Len() > c.maxEntries {
+				oldest := recent.Back()
+				recent.Remove(oldest)
+				delete(cache, oldest.Value.(string)) //nolint:forcetypeassert
+			}
+		case failed := <-c.forgets:
+			// The entry may have been evicted and the key computed again meanwhile
+			if e, ok := cache[failed.key]; ok && e == failed.entry {
+				recent.Remove(e.element)
+				delete(cache, failed.key)
+			}
+		case left := <-c.leaves:
+			e := left.entry
+			select {
+			case <-e.ready:
+			default:
+				e.refs--
+				if e.refs == 0 {
+					e.cancel()
+					if cached, ok := cache[left.key]; ok && cached == e {
+						recent.Remove(e.element)
+						delete(cache, left.key)
+					}
+				}
+			}
 		}
-		go e.deliver(req.response)
 	}
 }
 
-func (e *entry) call(f Func, key string) {
+func (e *entry) call(ctx context.Context, f Func, key string, forgets chan<- forget) {
+	defer e.cancel()
+	defer func() {
+		if e.res.err != nil {
+			forgets <- forget{key, e}
+		}
+	}()
 	defer close(e.ready)
 	defer func(e *entry) {
 		if r := recover(); r != nil {
-			e.res.err = errors.New("Recovered in f")
+			e.res.err = errRecovered
 		}
 	}(e)
-	e.res.value, e.res.err = f(key)
+	e.res.value, e.res.err = f(ctx, key)
 }
 
 func (e *entry) deliver(response chan<- result) {
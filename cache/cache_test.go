@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -54,8 +57,8 @@ var urls = []string{
 	"https://www.ted.com/talks/jane_doe_how_to_build_resilience",
 }
 
-func httpGetBody(url string) func() (resultValue, error) {
-	return func() (resultValue, error) {
+func httpGetBody(url string) func() ([]byte, error) {
+	return func() ([]byte, error) {
 		resp, err := http.Get(url)
 		if err != nil {
 			return nil, err
@@ -65,7 +68,7 @@ func httpGetBody(url string) func() (resultValue, error) {
 	}
 }
 
-func httpGetBody1(url string) (resultValue, error) {
+func httpGetBody1(_ context.Context, url string) ([]byte, error) {
 	// fmt.Printf("Calling %s\n", url)
 	if url == "https://www.wikipedia.org" {
 		panic("Panic for " + url)
@@ -91,13 +94,13 @@ func incomingUrls(urls []string) <-chan string {
 
 func TestConcurrent(t *testing.T) {
 	startAll := time.Now()
-	cache := NewCache(httpGetBody1)
+	cache := NewCache(httpGetBody1, 0)
 	var n sync.WaitGroup
 	for url := range incomingUrls(urls) {
 		n.Add(1)
 		go func(url string) {
 			start := time.Now()
-			value, err := cache.Get(url)
+			value, err := cache.Get(context.Background(), url)
 			if err != nil {
 				t.Logf("%-25s %-15s error: %v\n", url, time.Since(start), err)
 			} else {
@@ -109,3 +112,100 @@ func TestConcurrent(t *testing.T) {
 	n.Wait()
 	t.Logf("%-15s\n", time.Since(startAll))
 }
+
+func TestFailedCallsAreNotCached(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewCache(func(_ context.Context, key string) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("first call fails")
+		}
+		return []byte(key), nil
+	}, 0)
+	ctx := context.Background()
+
+	_, err := cache.Get(ctx, "a")
+	if err == nil {
+		t.Fatal("expected the first call to fail")
+	}
+	// The failed entry is dropped asynchronously
+	var value []byte
+	for i := 0; i < 100; i++ {
+		if value, err = cache.Get(ctx, "a"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil || string(value) != "a" {
+		t.Fatalf("expected a retry to succeed, got %q %v", value, err)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewCache(func(_ context.Context, key string) ([]byte, error) {
+		calls.Add(1)
+		return []byte(key), nil
+	}, 2)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := cache.Get(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a and b are computed, c evicts b as a was used more recently, b is computed again
+	if got := calls.Load(); got != 4 {
+		t.Fatalf("expected 4 computations, got %d", got)
+	}
+}
+
+func TestComputationOutlivesFirstCaller(t *testing.T) {
+	release := make(chan struct{})
+	canceled := make(chan struct{})
+	started := make(chan struct{}, 1)
+	cache := NewCache(func(ctx context.Context, key string) ([]byte, error) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return []byte(key), nil
+		case <-ctx.Done():
+			close(canceled)
+			return nil, ctx.Err()
+		}
+	}, 0)
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() {
+		_, err := cache.Get(firstCtx, "a")
+		firstDone <- err
+	}()
+	<-started
+	secondDone := make(chan []byte)
+	go func() {
+		value, _ := cache.Get(context.Background(), "a")
+		secondDone <- value
+	}()
+	// Let the second caller join before the first one leaves
+	time.Sleep(10 * time.Millisecond)
+	cancelFirst()
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first caller to be canceled, got %v", err)
+	}
+	close(release)
+	if value := <-secondDone; string(value) != "a" {
+		t.Fatalf("expected the second caller to get the value, got %q", value)
+	}
+
+	// Once every caller is gone the computation is canceled
+	release = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _, _ = cache.Get(ctx, "b") }()
+	<-started
+	cancel()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the abandoned computation to be canceled")
+	}
+}
@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// record header: insertion sequence, expiry in unix millis (0 for none), crc32 of the value
+	headerSize = 8 + 8 + 4
+	// compactTxMaxSize bounds the size of each transaction while compacting
+	compactTxMaxSize = 64 << 20
+)
+
+var (
+	valuesBucket = []byte("values") //nolint:gochecknoglobals
+	orderBucket  = []byte("order")  //nolint:gochecknoglobals
+
+	errCorrupted = errors.New("corrupted disk cache record")
+	crcTable     = crc32.MakeTable(crc32.Castagnoli) //nolint:gochecknoglobals
+)
+
+// DiskOptions configures a DiskStore
+type DiskOptions struct {
+	// MaxBytes bounds the stored records, the oldest are evicted beyond it. 0 means unbounded.
+	MaxBytes int64
+}
+
+// DiskStore is an on-disk Backend which survives restarts. Records are evicted
+// in insertion order once the store grows beyond its size limit and are checked against a
+// checksum when read.
+type DiskStore struct {
+	db   *bolt.DB
+	opts DiskOptions
+
+	sizeLock sync.Mutex
+	size     int64
+}
+
+// OpenDiskStore opens or creates the store at path. A file grown well beyond the size limit
+// is compacted first.
+func OpenDiskStore(path string, opts DiskOptions) (*DiskStore, error) {
+	if err := compactIfNeeded(path, opts.MaxBytes); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to compact disk cache")
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &DiskStore{db: db, opts: opts}
+	err = db.Update(func(tx *bolt.Tx) error {
+		values, err := tx.CreateBucketIfNotExists(valuesBucket)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.CreateBucketIfNotExists(orderBucket); err != nil {
+			return err
+		}
+		return values.ForEach(func(_, record []byte) error {
+			s.size += int64(len(record))
+			return nil
+		})
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	log.Info().Str("path", path).Int64("size", s.size).Msg("Disk cache opened")
+	return s, nil
+}
+
+// Close closes the underlying file
+func (s *DiskStore) Close() error {
+	return s.db.Close()
+}
+
+// Get implements Backend. Corrupted and expired records are removed and reported missing.
+func (s *DiskStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	expiresAt, ok, err := s.read(key, func(record []byte) {
+		// The record is only valid during the transaction
+		value = append([]byte{}, record[headerSize:]...)
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		return nil, false, s.Delete(ctx, key)
+	}
+	return value, true, nil
+}
+
+// TTL implements Backend
+func (s *DiskStore) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	expiresAt, ok, err := s.read(key, nil)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if expiresAt.IsZero() {
+		return 0, true, nil
+	}
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return 0, false, s.Delete(ctx, key)
+	}
+	return ttl, true, nil
+}
+
+// read checks the record of key and hands it to f within the transaction. A corrupted record is
+// removed and reported missing.
+func (s *DiskStore) read(key string, f func(record []byte)) (time.Time, bool, error) {
+	var expiresAt time.Time
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(valuesBucket).Get([]byte(key))
+		if record == nil {
+			return nil
+		}
+		if len(record) < headerSize || crc32.Checksum(record[headerSize:], crcTable) != binary.BigEndian.Uint32(record[16:headerSize]) {
+			return errCorrupted
+		}
+		if millis := int64(binary.BigEndian.Uint64(record[8:16])); millis != 0 {
+			expiresAt = time.UnixMilli(millis)
+		}
+		found = true
+		if f != nil {
+			f(record)
+		}
+		return nil
+	})
+	if errors.Is(err, errCorrupted) {
+		log.Error().Err(err).Str("key", key).Msg("Dropping disk cache record")
+		return expiresAt, false, s.Delete(context.Background(), key)
+	}
+	return expiresAt, found, err
+}
+
+// Set implements Backend, it evicts the oldest records beyond the size limit
+func (s *DiskStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.sizeLock.Lock()
+	defer s.sizeLock.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		values := tx.Bucket(valuesBucket)
+		order := tx.Bucket(orderBucket)
+		size := s.size
+		if err := s.remove(values, order, []byte(key), &size); err != nil {
+			return err
+		}
+
+		seq, err := order.NextSequence()
+		if err != nil {
+			return err
+		}
+		record := make([]byte, headerSize+len(value))
+		binary.BigEndian.PutUint64(record[0:8], seq)
+		if ttl > 0 {
+			binary.BigEndian.PutUint64(record[8:16], uint64(time.Now().Add(ttl).UnixMilli()))
+		}
+		binary.BigEndian.PutUint32(record[16:headerSize], crc32.Checksum(value, crcTable))
+		copy(record[headerSize:], value)
+		if err = values.Put([]byte(key), record); err != nil {
+			return err
+		}
+		if err = order.Put(record[0:8], []byte(key)); err != nil {
+			return err
+		}
+		size += int64(len(record))
+
+		cursor := order.Cursor()
+		for seqKey, oldest := cursor.First(); seqKey != nil && s.opts.MaxBytes > 0 && size > s.opts.MaxBytes; seqKey, oldest = cursor.First() {
+			if values.Get(oldest) == nil {
+				err = order.Delete(seqKey)
+			} else {
+				err = s.remove(values, order, oldest, &size)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		s.size = size
+		return nil
+	})
+}
+
+// Delete implements Backend
+func (s *DiskStore) Delete(_ context.Context, key string) error {
+	s.sizeLock.Lock()
+	defer s.sizeLock.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		size := s.size
+		if err := s.remove(tx.Bucket(valuesBucket), tx.Bucket(orderBucket), []byte(key), &size); err != nil {
+			return err
+		}
+		s.size = size
+		return nil
+	})
+}
+
+// Size returns the bytes used by the stored records
+func (s *DiskStore) Size() int64 {
+	s.sizeLock.Lock()
+	defer s.sizeLock.Unlock()
+	return s.size
+}
+
+func (s *DiskStore) remove(values *bolt.Bucket, order *bolt.Bucket, key []byte, size *int64) error {
+	record := values.Get(key)
+	if record == nil {
+		return nil
+	}
+	if len(record) >= 8 {
+		if err := order.Delete(record[0:8]); err != nil {
+			return err
+		}
+	}
+	*size -= int64(len(record))
+	return values.Delete(key)
+}
+
+func compactIfNeeded(path string, maxBytes int64) error {
+	info, err := os.Stat(path)
+	if err != nil || maxBytes <= 0 || info.Size() <= 2*maxBytes {
+		return nil //nolint:nilerr // a missing file needs no compaction
+	}
+	src, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	compactPath := path + ".compact"
+	dst, err := bolt.Open(compactPath, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		_ = src.Close()
+		return err
+	}
+	err = bolt.Compact(dst, src, compactTxMaxSize)
+	_ = src.Close()
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(compactPath)
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	log.Info().Str("path", path).Int64("size", info.Size()).Msg("Disk cache compacted")
+	return os.Rename(compactPath, path)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestDiskStore(t *testing.T, opts DiskOptions) (*DiskStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	store, err := OpenDiskStore(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, path
+}
+
+func TestDiskStoreSurvivesReopen(t *testing.T) {
+	store, path := openTestDiskStore(t, DiskOptions{})
+	ctx := context.Background()
+	if err := store.Set(ctx, "a", []byte("value a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenDiskStore(path, DiskOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close() //nolint:errcheck
+	value, ok, err := store.Get(ctx, "a")
+	if err != nil || !ok || string(value) != "value a" {
+		t.Fatalf("expected the value to survive a reopen, got %q %v", value, ok)
+	}
+	if store.Size() != int64(headerSize+len("value a")) {
+		t.Fatalf("unexpected size %d", store.Size())
+	}
+}
+
+func TestDiskStoreEvictsOldest(t *testing.T) {
+	store, _ := openTestDiskStore(t, DiskOptions{MaxBytes: 2 * (headerSize + 10)})
+	defer store.Close() //nolint:errcheck
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Set(ctx, key, []byte("0123456789"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Fatal("expected the oldest record to be evicted")
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, ok, _ := store.Get(ctx, key); !ok {
+			t.Fatalf("expected %s to be kept", key)
+		}
+	}
+	if store.Size() > 2*(headerSize+10) {
+		t.Fatalf("size %d is beyond the limit", store.Size())
+	}
+}
+
+func TestDiskStoreDropsCorruptedRecords(t *testing.T) {
+	store, _ := openTestDiskStore(t, DiskOptions{})
+	defer store.Close() //nolint:errcheck
+	ctx := context.Background()
+	if err := store.Set(ctx, "a", []byte("value a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		record := append([]byte{}, tx.Bucket(valuesBucket).Get([]byte("a"))...)
+		record[len(record)-1] ^= 0xff
+		return tx.Bucket(valuesBucket).Put([]byte("a"), record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Fatal("expected the corrupted record to be a miss")
+	}
+	if store.Size() != 0 {
+		t.Fatalf("expected the corrupted record to be removed, size %d", store.Size())
+	}
+}
+
+func TestDiskStoreExpiry(t *testing.T) {
+	store, _ := openTestDiskStore(t, DiskOptions{})
+	defer store.Close() //nolint:errcheck
+	ctx := context.Background()
+	if err := store.Set(ctx, "short", []byte("value"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "long", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := store.Get(ctx, "short"); ok {
+		t.Fatal("expected the expired record to be a miss")
+	}
+	if ttl, ok, err := store.TTL(ctx, "long"); err != nil || !ok || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("unexpected ttl %v %v %v", ttl, ok, err)
+	}
+	if store.Size() != int64(headerSize+len("value")) {
+		t.Fatalf("expected the expired record to be removed, size %d", store.Size())
+	}
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackend is a Backend kept in a Redis protocol server shared by the replicas
+type RedisBackend struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisBackend creates a backend storing each key under prefix
+func NewRedisBackend(client redis.UniversalClient, prefix string) *RedisBackend {
+	return &RedisBackend{client: client, prefix: prefix}
+}
+
+// Get implements Backend
+func (r *RedisBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set implements Backend
+func (r *RedisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+// Delete implements Backend
+func (r *RedisBackend) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+// TTL implements Backend
+func (r *RedisBackend) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	ttl, err := r.client.TTL(ctx, r.prefix+key).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	// The client reports -2 for a missing key and -1 for a key without expiry
+	switch ttl {
+	case -2:
+		return 0, false, nil
+	case -1:
+		return 0, true, nil
+	}
+	return ttl, true, nil
+}
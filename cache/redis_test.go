@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisBackendIsShared(t *testing.T) {
+	server := miniredis.RunT(t)
+	newReplica := func() *RedisBackend {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		return NewRedisBackend(client, "timeline:")
+	}
+	first, second := newReplica(), newReplica()
+	ctx := context.Background()
+
+	if err := first.Set(ctx, "a", []byte("value a"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("timeline:a") {
+		t.Fatal("expected the key to be prefixed")
+	}
+	if value, ok, err := second.Get(ctx, "a"); err != nil || !ok || string(value) != "value a" {
+		t.Fatalf("expected the other replica to see the value, got %q %v %v", value, ok, err)
+	}
+	if ttl, ok, err := second.TTL(ctx, "a"); err != nil || !ok || ttl != time.Minute {
+		t.Fatalf("unexpected ttl %v %v %v", ttl, ok, err)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if _, ok, err := second.Get(ctx, "a"); err != nil || ok {
+		t.Fatalf("expected the expired value to be a miss, got %v %v", ok, err)
+	}
+
+	if err := first.Set(ctx, "b", []byte("value b"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok, err := first.TTL(ctx, "b"); err != nil || !ok || ttl != 0 {
+		t.Fatalf("unexpected ttl %v %v %v", ttl, ok, err)
+	}
+	if err := second.Delete(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := first.TTL(ctx, "b"); err != nil || ok {
+		t.Fatalf("expected the deleted value to be missing, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,91 @@
+// Package coalesce shares one execution of identical concurrent calls among all their callers
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errRecovered = errors.New("recovered in fn")
+
+// Group coalesces calls by key. A call keeps running while any of its callers waits for it and is
+// canceled once all of them are gone. Results are shared, callers must not modify them.
+type Group[T any] struct {
+	lock  sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	done   chan struct{}
+	value  T
+	err    error
+	refs   int
+	cancel context.CancelFunc
+}
+
+// Do returns the result of fn for key, joining the running call of key when there is one.
+// shared reports whether the result was delivered to several callers.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (value T, shared bool, err error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.refs++
+	} else {
+		// The call outlives the caller starting it as long as others wait for it
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[T]{done: make(chan struct{}), refs: 1, cancel: cancel}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		g.lock.Lock()
+		shared = c.refs > 1
+		g.lock.Unlock()
+		return c.value, shared, c.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		c.refs--
+		if c.refs == 0 {
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.lock.Unlock()
+		var zero T
+		return zero, false, ctx.Err()
+	}
+}
+
+// Waiters returns the number of callers waiting for the running call of key
+func (g *Group[T]) Waiters(key string) int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.refs
+	}
+	return 0
+}
+
+func (g *Group[T]) run(ctx context.Context, key string, c *call[T], fn func(ctx context.Context) (T, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = errRecovered
+		}
+		c.cancel()
+		g.lock.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.lock.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = fn(ctx)
+}
@@ -0,0 +1,99 @@
+package coalesce_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/coalesce"
+)
+
+func TestCallsAreShared(t *testing.T) {
+	var g coalesce.Group[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, shared, err := g.Do(context.Background(), "key", fn)
+			assert.NoError(t, err)
+			assert.True(t, shared)
+			assert.Equal(t, 42, value)
+		}()
+	}
+	require.Eventually(t, func() bool { return g.Waiters("key") == 3 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Zero(t, g.Waiters("key"))
+}
+
+func TestCallSurvivesFirstCaller(t *testing.T) {
+	var g coalesce.Group[int]
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() {
+		_, _, err := g.Do(firstCtx, "key", fn)
+		firstDone <- err
+	}()
+	require.Eventually(t, func() bool { return g.Waiters("key") == 1 }, time.Second, time.Millisecond)
+	secondDone := make(chan int)
+	go func() {
+		value, _, err := g.Do(context.Background(), "key", fn)
+		assert.NoError(t, err)
+		secondDone <- value
+	}()
+	require.Eventually(t, func() bool { return g.Waiters("key") == 2 }, time.Second, time.Millisecond)
+
+	cancelFirst()
+	require.ErrorIs(t, <-firstDone, context.Canceled)
+	close(release)
+	assert.Equal(t, 1, <-secondDone)
+}
+
+func TestCallIsCanceledWithoutCallers(t *testing.T) {
+	var g coalesce.Group[int]
+	canceled := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, _, _ = g.Do(ctx, "key", func(ctx context.Context) (int, error) {
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return 0, ctx.Err()
+		})
+	}()
+	require.Eventually(t, func() bool { return g.Waiters("key") == 1 }, time.Second, time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-canceled, context.Canceled)
+
+	// A new call starts afresh
+	value, _, err := g.Do(context.Background(), "key", func(context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	_, _, err = g.Do(context.Background(), "fails", func(context.Context) (int, error) { return 0, errors.New("failed") })
+	require.Error(t, err)
+}
@@ -5,3 +5,12 @@ import "errors"
 
 // ErrNoDefaultMongoClient is returned when no MongoDB client is registered with a connection string
 var ErrNoDefaultMongoClient = errors.New("no mongodb client is registered with a connection string")
+
+// ErrMaxStalenessWithPrimary is returned when a max staleness is configured for the primary read preference
+var ErrMaxStalenessWithPrimary = errors.New("max staleness can not be used with the primary read preference")
+
+// ErrInvalidReadConcern is returned when the read concern level is not known
+var ErrInvalidReadConcern = errors.New("invalid read concern")
+
+// ErrUnknownLane is returned when an option refers to a lane that does not exist
+var ErrUnknownLane = errors.New("unknown lane")
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// IndexSpec is an index the timeline queries rely on
+type IndexSpec struct {
+	Name string
+	Keys []string
+}
+
+// IndexReport is the outcome of checking the indexes of one timeline collection
+type IndexReport struct {
+	Lane       string
+	DBName     string
+	Collection string
+	// Missing lists the recommended indexes no existing index can serve
+	Missing []IndexSpec
+	// Unused lists existing indexes without any access since the server started
+	Unused []string
+	// Created lists the indexes created when applying
+	Created []string
+}
+
+// RecommendedIndexes returns the indexes needed by the range overlap $match of a lane. The
+// $or clauses filter on startTimestamp and endTimestamp ranges, so each needs its own index.
+func (l Lane) RecommendedIndexes() []IndexSpec {
+	if l.PerChannel() {
+		return []IndexSpec{
+			{Name: "startTimestamp_1_endTimestamp_1", Keys: []string{"startTimestamp", "endTimestamp"}},
+			{Name: "endTimestamp_1", Keys: []string{"endTimestamp"}},
+		}
+	}
+	return []IndexSpec{
+		{Name: "siteId_1_channelId_1_startTimestamp_1_endTimestamp_1", Keys: []string{"siteId", "channelId", "startTimestamp", "endTimestamp"}},
+		{Name: "siteId_1_channelId_1_endTimestamp_1", Keys: []string{"siteId", "channelId", "endTimestamp"}},
+	}
+}
+
+// SatisfiedBy reports whether an existing index with the given keys can serve the spec,
+// which is the case when the spec keys are a prefix of the existing keys
+func (s IndexSpec) SatisfiedBy(keys []string) bool {
+	return len(keys) >= len(s.Keys) && slices.Equal(keys[:len(s.Keys)], s.Keys)
+}
+
+// CheckIndexes inspects every timeline collection and reports missing and unused indexes.
+// When apply is true the missing indexes are created.
+func CheckIndexes(ctx context.Context, client *mongo.Client, apply bool) ([]IndexReport, error) {
+	var reports []IndexReport
+	for _, lane := range TimelineLanes() {
+		collNames, err := LaneCollectionNames(ctx, client, lane)
+		if err != nil {
+			return reports, err
+		}
+		for _, collName := range collNames {
+			report, err := checkCollectionIndexes(ctx, client.Database(lane.DBName).Collection(collName), lane, apply)
+			if err != nil {
+				return reports, err
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// LaneCollectionNames lists the existing collections of a lane
+func LaneCollectionNames(ctx context.Context, client *mongo.Client, lane Lane) ([]string, error) {
+	filter := bson.D{{Key: "name", Value: lane.Collection}}
+	if lane.PerChannel() {
+		filter = bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(lane.CollectionPrefix) + `\d+_\d+$`}}}}
+	}
+	return client.Database(lane.DBName).ListCollectionNames(ctx, filter, options.ListCollections().SetNameOnly(true))
+}
+
+func checkCollectionIndexes(ctx context.Context, collection *mongo.Collection, lane Lane, apply bool) (IndexReport, error) {
+	report := IndexReport{Lane: lane.Name, DBName: lane.DBName, Collection: collection.Name()}
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return report, err
+	}
+	existing := make([][]string, 0, len(specs))
+	for _, spec := range specs {
+		existing = append(existing, indexKeys(spec.KeysDocument))
+	}
+	for _, want := range lane.RecommendedIndexes() {
+		if !slices.ContainsFunc(existing, want.SatisfiedBy) {
+			report.Missing = append(report.Missing, want)
+		}
+	}
+
+	report.Unused, err = unusedIndexes(ctx, collection)
+	if err != nil {
+		// $indexStats needs the clusterMonitor role, the missing index check is still useful without it
+		log.Warn().Err(err).Str("collection", collection.Name()).Msg("Unable to read index usage")
+	}
+
+	if apply && len(report.Missing) > 0 {
+		models := make([]mongo.IndexModel, 0, len(report.Missing))
+		for _, spec := range report.Missing {
+			keys := make(bson.D, 0, len(spec.Keys))
+			for _, key := range spec.Keys {
+				keys = append(keys, bson.E{Key: key, Value: 1})
+			}
+			models = append(models, mongo.IndexModel{Keys: keys, Options: options.Index().SetName(spec.Name)})
+		}
+		report.Created, err = collection.Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return report, err
+		}
+		log.Info().Str("collection", collection.Name()).Strs("created", report.Created).Msg("Created indexes")
+	}
+	return report, nil
+}
+
+func unusedIndexes(ctx context.Context, collection *mongo.Collection) ([]string, error) {
+	cursor, err := collection.Aggregate(ctx, bson.A{bson.D{{Key: "$indexStats", Value: bson.D{}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx) //nolint:errcheck
+
+	var stats []struct {
+		Name     string `bson:"name"`
+		Accesses struct {
+			Ops int64 `bson:"ops"`
+		} `bson:"accesses"`
+	}
+	if err = cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	var unused []string
+	for _, stat := range stats {
+		if stat.Name != "_id_" && stat.Accesses.Ops == 0 {
+			unused = append(unused, stat.Name)
+		}
+	}
+	return unused, nil
+}
+
+func indexKeys(keysDocument bson.Raw) []string {
+	elements, err := keysDocument.Elements()
+	if err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(elements))
+	for _, element := range elements {
+		keys = append(keys, element.Key())
+	}
+	return keys
+}
@@ -0,0 +1,34 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtpl1/cacheserver/db"
+)
+
+func TestIndexSpecSatisfiedBy(t *testing.T) {
+	spec := db.IndexSpec{Name: "startTimestamp_1_endTimestamp_1", Keys: []string{"startTimestamp", "endTimestamp"}}
+
+	assert.True(t, spec.SatisfiedBy([]string{"startTimestamp", "endTimestamp"}))
+	assert.True(t, spec.SatisfiedBy([]string{"startTimestamp", "endTimestamp", "objectCount"}), "a longer index with the same prefix serves the spec")
+	assert.False(t, spec.SatisfiedBy([]string{"startTimestamp"}))
+	assert.False(t, spec.SatisfiedBy([]string{"endTimestamp", "startTimestamp"}), "key order matters")
+	assert.False(t, spec.SatisfiedBy([]string{"_id"}))
+}
+
+func TestTimelineLanes(t *testing.T) {
+	for _, lane := range db.TimelineLanes() {
+		specs := lane.RecommendedIndexes()
+		assert.NotEmpty(t, specs, lane.Name)
+		if lane.PerChannel() {
+			assert.Equal(t, "startTimestamp", specs[0].Keys[0], lane.Name)
+		} else {
+			assert.Equal(t, []string{"siteId", "channelId"}, specs[0].Keys[:2], lane.Name)
+		}
+	}
+
+	lanes := db.TimelineLanes()
+	assert.Equal(t, "vVideoClips_5_7", lanes[0].CollectionName(5, 7))
+	assert.Equal(t, "dasEvents", lanes[3].CollectionName(5, 7))
+}
@@ -0,0 +1,68 @@
+package db
+
+import "fmt"
+
+// Lane describes where the documents of a timeline lane are stored
+type Lane struct {
+	Name   string
+	DBName string
+	// CollectionPrefix is followed by <siteId>_<channelId> for lanes with a collection per channel
+	CollectionPrefix string
+	// Collection is set for lanes sharing one collection filtered by siteId and channelId
+	Collection string
+}
+
+// TimelineLanes returns the lanes served on the timeline
+func TimelineLanes() []Lane {
+	return []Lane{
+		{Name: LaneRecordings, DBName: "ivms_30", CollectionPrefix: "vVideoClips_"},
+		{Name: LaneHumans, DBName: "pvaDB", CollectionPrefix: "pva_HUMAN_"},
+		{Name: LaneVehicles, DBName: "pvaDB", CollectionPrefix: "pva_VEHICLE_"},
+		{Name: LaneEvents, DBName: "dasDB", Collection: "dasEvents"},
+	}
+}
+
+// DocumentLanes returns the lanes whose documents timeline/all returns. Its events are read from
+// the dasEvents database as they always were, the merged segments of the websocket from dasDB.
+func DocumentLanes() []Lane {
+	lanes := TimelineLanes()
+	for i := range lanes {
+		if lanes[i].Name == LaneEvents {
+			lanes[i].DBName = "dasEvents"
+		}
+	}
+	return lanes
+}
+
+// DocumentLane returns the document lane called name
+func DocumentLane(name string) (Lane, bool) {
+	for _, lane := range DocumentLanes() {
+		if lane.Name == name {
+			return lane, true
+		}
+	}
+	return Lane{}, false
+}
+
+// LaneByName returns the timeline lane called name
+func LaneByName(name string) (Lane, bool) {
+	for _, lane := range TimelineLanes() {
+		if lane.Name == name {
+			return lane, true
+		}
+	}
+	return Lane{}, false
+}
+
+// PerChannel reports whether the lane keeps one collection per site and channel
+func (l Lane) PerChannel() bool {
+	return l.Collection == ""
+}
+
+// CollectionName returns the collection holding the documents of a site and channel
+func (l Lane) CollectionName(siteID int, channelID int) string {
+	if !l.PerChannel() {
+		return l.Collection
+	}
+	return fmt.Sprintf("%s%d_%d", l.CollectionPrefix, siteID, channelID)
+}
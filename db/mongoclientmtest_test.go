@@ -44,5 +44,5 @@ func TestMockMongoClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, count, 73)
+	assert.Equal(t, 72, count, "the fixture holds 72 documents")
 }
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// Lane names used to select per lane read options
+const (
+	LaneRecordings = "recordings"
+	LaneHumans     = "humans"
+	LaneVehicles   = "vehicles"
+	LaneEvents     = "events"
+)
+
+// DefaultLiveTailWindow is how close to now a query must end to be treated as a live tail query
+const DefaultLiveTailWindow = 5 * time.Minute
+
+// ReadOptions holds the read preference and read concern used for historical queries
+type ReadOptions struct {
+	// ReadPreference is one of primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	ReadPreference string
+	// MaxStaleness is only valid when ReadPreference is not primary
+	MaxStaleness time.Duration
+	// ReadConcern is one of local, available, majority, linearizable or snapshot
+	ReadConcern string
+}
+
+// ClientOptions holds the read routing configuration for timeline queries
+type ClientOptions struct {
+	// Historical is applied to every lane unless overridden in Lanes
+	Historical ReadOptions
+	// Lanes overrides the historical read options per lane, non empty fields win
+	Lanes map[string]ReadOptions
+	// LiveTailWindow is the window before now in which queries stay on the primary
+	LiveTailWindow time.Duration
+}
+
+var (
+	clientOptions     = ClientOptions{LiveTailWindow: DefaultLiveTailWindow} //nolint:gochecknoglobals
+	clientOptionsLock sync.RWMutex                                           //nolint:gochecknoglobals
+)
+
+// SetClientOptions validates and installs the read routing configuration
+func SetClientOptions(opts ClientOptions) error {
+	if _, err := opts.Historical.collectionOptions(); err != nil {
+		return err
+	}
+	for lane := range opts.Lanes {
+		switch lane {
+		case LaneRecordings, LaneHumans, LaneVehicles, LaneEvents:
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownLane, lane)
+		}
+		if _, err := opts.ReadOptionsFor(lane).collectionOptions(); err != nil {
+			return fmt.Errorf("lane %s: %w", lane, err)
+		}
+	}
+	if opts.LiveTailWindow <= 0 {
+		opts.LiveTailWindow = DefaultLiveTailWindow
+	}
+	clientOptionsLock.Lock()
+	defer clientOptionsLock.Unlock()
+	clientOptions = opts
+	return nil
+}
+
+// GetClientOptions returns the installed read routing configuration
+func GetClientOptions() ClientOptions {
+	clientOptionsLock.RLock()
+	defer clientOptionsLock.RUnlock()
+	return clientOptions
+}
+
+// ReadOptionsFor returns the historical read options of a lane
+func (o ClientOptions) ReadOptionsFor(lane string) ReadOptions {
+	ro := o.Historical
+	override, ok := o.Lanes[lane]
+	if !ok {
+		return ro
+	}
+	if override.ReadPreference != "" {
+		ro.ReadPreference = override.ReadPreference
+	}
+	if override.MaxStaleness != 0 {
+		ro.MaxStaleness = override.MaxStaleness
+	}
+	if override.ReadConcern != "" {
+		ro.ReadConcern = override.ReadConcern
+	}
+	return ro
+}
+
+// IsLiveTail reports whether a query ending at endTimestamp (unix millis) touches the live tail
+func (o ClientOptions) IsLiveTail(endTimestamp int64) bool {
+	return endTimestamp >= time.Now().Add(-o.LiveTailWindow).UnixMilli()
+}
+
+// LaneCollection returns the collection of a lane with the read options of the installed
+// configuration applied. Live tail queries always go to the primary with driver defaults.
+func LaneCollection(client *mongo.Client, lane string, dbName string, collName string, live bool) *mongo.Collection {
+	if live {
+		return client.Database(dbName).Collection(collName)
+	}
+	// SetClientOptions validates the options, so this only fails for options installed without it
+	opts, err := GetClientOptions().ReadOptionsFor(lane).collectionOptions()
+	if err != nil {
+		log.Error().Err(err).Str("lane", lane).Msg("Invalid read options, using driver defaults")
+		return client.Database(dbName).Collection(collName)
+	}
+	return client.Database(dbName).Collection(collName, opts)
+}
+
+func (ro ReadOptions) collectionOptions() (*options.CollectionOptionsBuilder, error) {
+	opts := options.Collection()
+	if ro.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(ro.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		var rpOpts []readpref.Option
+		if ro.MaxStaleness > 0 {
+			if mode == readpref.PrimaryMode {
+				return nil, ErrMaxStalenessWithPrimary
+			}
+			rpOpts = append(rpOpts, readpref.WithMaxStaleness(ro.MaxStaleness))
+		}
+		rp, err := readpref.New(mode, rpOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	} else if ro.MaxStaleness > 0 {
+		return nil, ErrMaxStalenessWithPrimary
+	}
+	switch ro.ReadConcern {
+	case "":
+	case "local", "available", "majority", "linearizable", "snapshot":
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: ro.ReadConcern})
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidReadConcern, ro.ReadConcern)
+	}
+	return opts, nil
+}
@@ -0,0 +1,57 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtpl1/cacheserver/db"
+)
+
+func TestReadOptionsFor(t *testing.T) {
+	opts := db.ClientOptions{
+		Historical: db.ReadOptions{ReadPreference: "secondaryPreferred", MaxStaleness: 90 * time.Second},
+		Lanes: map[string]db.ReadOptions{
+			db.LaneEvents: {ReadConcern: "majority"},
+			db.LaneHumans: {ReadPreference: "nearest", MaxStaleness: 2 * time.Minute},
+		},
+	}
+
+	assert.Equal(t, opts.Historical, opts.ReadOptionsFor(db.LaneRecordings), "Lanes without override use the historical options")
+	assert.Equal(t, db.ReadOptions{ReadPreference: "secondaryPreferred", MaxStaleness: 90 * time.Second, ReadConcern: "majority"}, opts.ReadOptionsFor(db.LaneEvents))
+	assert.Equal(t, db.ReadOptions{ReadPreference: "nearest", MaxStaleness: 2 * time.Minute}, opts.ReadOptionsFor(db.LaneHumans))
+}
+
+func TestSetClientOptions(t *testing.T) {
+	defer db.SetClientOptions(db.ClientOptions{}) //nolint:errcheck
+
+	err := db.SetClientOptions(db.ClientOptions{Historical: db.ReadOptions{ReadPreference: "primary", MaxStaleness: time.Minute}})
+	assert.True(t, errors.Is(err, db.ErrMaxStalenessWithPrimary), "max staleness must be rejected for primary")
+
+	err = db.SetClientOptions(db.ClientOptions{Historical: db.ReadOptions{ReadConcern: "eventual"}})
+	assert.True(t, errors.Is(err, db.ErrInvalidReadConcern), "unknown read concern must be rejected")
+
+	err = db.SetClientOptions(db.ClientOptions{Historical: db.ReadOptions{ReadPreference: "fastest"}})
+	assert.Error(t, err, "unknown read preference must be rejected")
+
+	err = db.SetClientOptions(db.ClientOptions{Lanes: map[string]db.ReadOptions{"cars": {ReadPreference: "secondary"}}})
+	assert.True(t, errors.Is(err, db.ErrUnknownLane), "unknown lane must be rejected")
+
+	err = db.SetClientOptions(db.ClientOptions{
+		Historical: db.ReadOptions{ReadPreference: "secondary", MaxStaleness: 90 * time.Second, ReadConcern: "majority"},
+		Lanes:      map[string]db.ReadOptions{db.LaneHumans: {ReadPreference: "nearest"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "nearest", db.GetClientOptions().ReadOptionsFor(db.LaneHumans).ReadPreference)
+	assert.Equal(t, db.DefaultLiveTailWindow, db.GetClientOptions().LiveTailWindow, "zero live tail window falls back to the default")
+}
+
+func TestIsLiveTail(t *testing.T) {
+	opts := db.ClientOptions{LiveTailWindow: time.Minute}
+	now := time.Now()
+
+	assert.True(t, opts.IsLiveTail(now.UnixMilli()))
+	assert.True(t, opts.IsLiveTail(now.Add(-30*time.Second).UnixMilli()))
+	assert.False(t, opts.IsLiveTail(now.Add(-2*time.Minute).UnixMilli()))
+}
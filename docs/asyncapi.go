@@ -0,0 +1,132 @@
+package docs
+
+import (
+	"reflect"
+
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+// timelineChannel is the websocket route of the timeline
+const timelineChannel = "/ws/timeline/site/:siteId/channel/:channelId"
+
+// correlationLane names the segments answering a correlate command
+const correlationLane = "correlation"
+
+// message is a message of the websocket
+type message struct {
+	name    string
+	summary string
+	payload schema
+}
+
+// clientMessages lists the messages a client sends
+func clientMessages(s schemas) []message {
+	return []message{
+		{
+			name:    "token",
+			summary: "Credentials of a websocket opened without them, sent first",
+			payload: s.of(reflect.TypeFor[auth.FirstMessage]()),
+		},
+		{
+			name:    "command",
+			summary: "Command replacing the running one",
+			payload: s.of(reflect.TypeFor[models.Command]()),
+		},
+	}
+}
+
+// serverMessages lists the messages answering the commands, {"type": key, key: payload}
+func serverMessages(s schemas) []message {
+	messages := []message{
+		{
+			name:    "status",
+			summary: "Start and end of a command, and queue positions of its lanes",
+			payload: envelope("status", schema{"anyOf": []schema{
+				s.of(reflect.TypeFor[models.CommandStatus]()),
+				s.of(reflect.TypeFor[models.QueuedStatus]()),
+			}}),
+		},
+		{
+			name:    "error",
+			summary: "Failure of a command or of the connection",
+			payload: envelope("error", text()),
+		},
+		{
+			name:    "coverage",
+			summary: "Recording coverage report of a coverage command",
+			payload: envelope("coverage", s.of(reflect.TypeFor[models.CoverageReport]())),
+		},
+	}
+	batch := schema{"anyOf": []schema{
+		s.of(reflect.TypeFor[models.LaneStatus]()),
+		s.of(reflect.TypeFor[[]models.Segment]()),
+		s.of(reflect.TypeFor[[]models.Bin]()),
+	}}
+	for _, lane := range append(laneNames(), correlationLane) {
+		messages = append(messages, message{
+			name:    lane,
+			summary: "Segments or density bins of the " + lane + " lane in batches framed by start and done",
+			payload: envelope(lane, batch),
+		})
+	}
+	return messages
+}
+
+// messageRefs collects the messages under their names and returns references to them
+func messageRefs(components schema, messages []message) []schema {
+	refs := make([]schema, len(messages))
+	for i, m := range messages {
+		components[m.name] = schema{"name": m.name, "summary": m.summary, "payload": m.payload}
+		refs[i] = schema{"$ref": "#/components/messages/" + m.name}
+	}
+	return refs
+}
+
+// AsyncAPI returns the AsyncAPI 2 document of the timeline websocket, the schemas are generated
+// from the types of the messages
+func AsyncAPI(version string) map[string]any {
+	s := schemas{}
+	messages := schema{}
+	return map[string]any{
+		"asyncapi": "2.6.0",
+		"info": schema{
+			"title":   "CacheServer timeline websocket",
+			"version": version,
+			"description": "A client sends commands and receives their answers. A new command cancels the " +
+				"running one, whose messages all come first. The same messages are streamed as " +
+				"server-sent events by /sse/timeline, the stream closing with an end event.",
+		},
+		"defaultContentType": mimeJSON,
+		"channels": schema{
+			openAPIPath(timelineChannel): schema{
+				"parameters": schema{
+					"siteId":    schema{"description": pathParams["siteId"], "schema": integer()},
+					"channelId": schema{"description": pathParams["channelId"], "schema": integer()},
+				},
+				"publish": schema{
+					"operationId": "sendCommand",
+					"message":     schema{"oneOf": messageRefs(messages, clientMessages(s))},
+				},
+				"subscribe": schema{
+					"operationId": "receiveMessages",
+					"message":     schema{"oneOf": messageRefs(messages, serverMessages(s))},
+				},
+			},
+		},
+		"components": schema{
+			"schemas":         s,
+			"messages":        messages,
+			"securitySchemes": asyncSecuritySchemes(),
+		},
+	}
+}
+
+// asyncSecuritySchemes are the credentials of the upgrade, or the token of the first message
+func asyncSecuritySchemes() schema {
+	return schema{
+		"bearer": schema{"type": "http", "scheme": "bearer"},
+		"apiKey": schema{"type": "httpApiKey", "in": "header", "name": auth.APIKeyHeader},
+		"token":  schema{"type": "httpApiKey", "in": "query", "name": auth.TokenQueryParam},
+	}
+}
@@ -0,0 +1,142 @@
+package docs_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/docs"
+)
+
+// refs returns the $ref values of a decoded document
+func refs(value any) []string {
+	var found []string
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if ref, ok := item.(string); ok && key == "$ref" {
+				found = append(found, ref)
+			}
+			found = append(found, refs(item)...)
+		}
+	case []any:
+		for _, item := range v {
+			found = append(found, refs(item)...)
+		}
+	}
+	return found
+}
+
+// resolve follows a local reference, #/components/schemas/Segment
+func resolve(doc map[string]any, ref string) bool {
+	var node any = doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		object, ok := node.(map[string]any)
+		if !ok {
+			return false
+		}
+		if node, ok = object[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// decode returns the JSON decoding of the encoding of doc
+func decode(t *testing.T, doc map[string]any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	return decoded
+}
+
+func TestDocumentsResolve(t *testing.T) {
+	openAPI := decode(t, docs.OpenAPI("1.2.3"))
+	asyncAPI := decode(t, docs.AsyncAPI("1.2.3"))
+	for name, doc := range map[string]map[string]any{"openapi": openAPI, "asyncapi": asyncAPI} {
+		assert.Equal(t, "1.2.3", doc["info"].(map[string]any)["version"], name)
+		found := refs(doc)
+		assert.NotEmpty(t, found, name)
+		for _, ref := range found {
+			assert.True(t, resolve(doc, ref), "%s: %s", name, ref)
+		}
+	}
+
+	schemas := openAPI["components"].(map[string]any)["schemas"].(map[string]any)
+	page := schemas["TimelinePage"].(map[string]any)
+	assert.Contains(t, page["required"], "segments")
+	assert.NotContains(t, page["required"], "nextCursor", "omitempty fields are optional")
+	laneSegment := schemas["LaneSegment"].(map[string]any)["properties"].(map[string]any)
+	assert.Contains(t, laneSegment, "timeStamp", "embedded fields are inlined")
+	commandStatus := asyncAPI["components"].(map[string]any)["schemas"].(map[string]any)["CommandStatus"].(map[string]any)
+	assert.Contains(t, commandStatus["required"], "counts", "status frames always carry counts")
+	expression := asyncAPI["components"].(map[string]any)["schemas"].(map[string]any)["Expression"].(map[string]any)
+	assert.Equal(t, "#/components/schemas/Expression", expression["properties"].(map[string]any)["args"].(map[string]any)["items"].(map[string]any)["$ref"],
+		"recursive types refer to themselves")
+}
+
+func TestValidateResponse(t *testing.T) {
+	const stats = "/site/:siteId/channel/:channelId/stats"
+	assert.NoError(t, docs.ValidateResponse(stats, 200, "application/json", []byte(`[{"lane":"humans","siteId":1,"channelId":2,"count":3}]`)))
+	assert.NoError(t, docs.ValidateResponse(stats, 400, "text/plain; charset=utf-8", []byte("invalid siteId")))
+	assert.ErrorIs(t, docs.ValidateResponse(stats, 200, "application/json", []byte(`[{"lane":"humans","siteId":1,"channelId":2}]`)), docs.ErrMismatch, "missing count")
+	assert.ErrorIs(t, docs.ValidateResponse(stats, 200, "application/json", []byte(`[{"lane":"humans","siteId":1,"channelId":2,"count":3,"size":4}]`)), docs.ErrMismatch, "unexpected size")
+	assert.ErrorIs(t, docs.ValidateResponse(stats, 200, "application/json", []byte(`[{"lane":"humans","siteId":"1","channelId":2,"count":3}]`)), docs.ErrMismatch, "string siteId")
+	assert.ErrorIs(t, docs.ValidateResponse(stats, 200, "application/json", []byte(`null`)), docs.ErrMismatch)
+	assert.ErrorIs(t, docs.ValidateResponse(stats, 200, "text/csv", nil), docs.ErrUndocumented)
+	assert.ErrorIs(t, docs.ValidateResponse(stats, 204, "application/json", nil), docs.ErrUndocumented)
+	assert.ErrorIs(t, docs.ValidateResponse("/site/:siteId/channel/:channelId/faces", 200, "application/json", nil), docs.ErrUndocumented)
+
+	const timeline = "/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all"
+	lines := `{"type":"humans","humans":{"siteId":5,"channelId":6,"timeStamp":1,"timeStampEnd":2}}
+{"type":"status","status":{"status":"done","counts":{"humans":1}}}
+`
+	assert.NoError(t, docs.ValidateResponse(timeline, 200, "application/x-ndjson", []byte(lines)))
+	assert.ErrorIs(t, docs.ValidateResponse(timeline, 200, "application/x-ndjson", []byte(`{"type":"faces","faces":{}}`)), docs.ErrMismatch)
+}
+
+func TestValidateMessage(t *testing.T) {
+	for _, msg := range []string{
+		`{"type":"humans","humans":{"commandId":"1","status":"start"}}`,
+		`{"type":"humans","humans":[{"commandId":"1","timeStamp":1,"timeStampEnd":2,"objectCount":3}]}`,
+		`{"type":"vehicles","vehicles":[{"timeStamp":1,"timeStampEnd":2,"objectCount":0,"coverage":0.5}]}`,
+		`{"type":"status","status":{"status":"queued","commandId":"1","lane":"humans","position":2}}`,
+		`{"type":"error","error":"invalid command"}`,
+	} {
+		assert.NoError(t, docs.ValidateMessage([]byte(msg)), msg)
+	}
+	assert.ErrorIs(t, docs.ValidateMessage([]byte(`{"type":"faces","faces":[]}`)), docs.ErrUndocumented)
+	assert.ErrorIs(t, docs.ValidateMessage([]byte(`{"type":"humans","humans":[{"timeStamp":-1,"timeStampEnd":2}]}`)), docs.ErrMismatch)
+	assert.ErrorIs(t, docs.ValidateMessage([]byte(`{"type":"status","status":{"status":"done"}}`)), docs.ErrMismatch)
+}
+
+func TestHandlers(t *testing.T) {
+	app := fiber.New()
+	app.Get("docs", docs.IndexHandler)
+	app.Get("docs/openapi.json", docs.OpenAPIHandler("1.2.3"))
+	app.Get("docs/asyncapi.json", docs.AsyncAPIHandler("1.2.3"))
+
+	for path, field := range map[string]string{"/docs/openapi.json": "openapi", "/docs/asyncapi.json": "asyncapi"} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get(fiber.HeaderContentType))
+		var doc map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+		_ = resp.Body.Close()
+		assert.Contains(t, doc, field)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/docs", nil))
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `href="docs/openapi.json"`)
+}
@@ -0,0 +1,50 @@
+// Package docs generates the OpenAPI document of the HTTP endpoints and the AsyncAPI document of
+// the timeline websocket from the types of their messages, and serves them
+package docs
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const index = `<!DOCTYPE html>
+<html>
+<head><title>CacheServer API</title></head>
+<body>
+<h1>CacheServer API</h1>
+<ul>
+<li><a href="docs/openapi.json">OpenAPI</a> of the HTTP endpoints</li>
+<li><a href="docs/asyncapi.json">AsyncAPI</a> of the timeline websocket</li>
+</ul>
+</body>
+</html>
+`
+
+// IndexHandler links the documents
+func IndexHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(index)
+}
+
+// OpenAPIHandler serves the OpenAPI document of version
+func OpenAPIHandler(version string) fiber.Handler {
+	return documentHandler(OpenAPI(version))
+}
+
+// AsyncAPIHandler serves the AsyncAPI document of version
+func AsyncAPIHandler(version string) fiber.Handler {
+	return documentHandler(AsyncAPI(version))
+}
+
+// documentHandler serves doc, encoded once
+func documentHandler(doc map[string]any) fiber.Handler {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	return func(c *fiber.Ctx) error {
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(data)
+	}
+}
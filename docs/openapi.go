@@ -0,0 +1,299 @@
+package docs
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+const (
+	mimeJSON   = "application/json"
+	mimeNDJSON = "application/x-ndjson"
+	mimeText   = "text/plain"
+	mimeCSV    = "text/csv"
+	mimeXLSX   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimeSSE    = "text/event-stream"
+)
+
+// parameter is a query or header parameter of an operation, the path parameters follow the path
+type parameter struct {
+	name        string
+	in          string
+	description string
+	schema      schema
+	required    bool
+}
+
+// operation is a GET endpoint of the HTTP app
+type operation struct {
+	// path is in the syntax of the routes, /site/:siteId
+	path        string
+	id          string
+	summary     string
+	description string
+	params      []parameter
+	// responses maps the statuses to the schemas of their media types, errors are text
+	responses map[int]map[string]schema
+}
+
+// pathParams describes the path parameters by name
+var pathParams = map[string]string{ //nolint:gochecknoglobals
+	"siteId":       "site",
+	"channelId":    "channel of the site",
+	"timeStamp":    "start of the range in unix milliseconds",
+	"timeStampEnd": "end of the range in unix milliseconds",
+}
+
+func query(name string, description string, s schema) parameter {
+	return parameter{name: name, in: "query", description: description, schema: s}
+}
+
+func requiredQuery(name string, description string, s schema) parameter {
+	p := query(name, description, s)
+	p.required = true
+	return p
+}
+
+func integer() schema {
+	return schema{"type": "integer", "format": "int64"}
+}
+
+func text() schema {
+	return schema{"type": "string"}
+}
+
+func enum(values ...string) schema {
+	return schema{"type": "string", "enum": values}
+}
+
+// laneNames returns the names of the timeline lanes
+func laneNames() []string {
+	lanes := db.TimelineLanes()
+	names := make([]string, len(lanes))
+	for i, lane := range lanes {
+		names[i] = lane.Name
+	}
+	return names
+}
+
+// envelope returns the schema of a message {"type": key, key: payload}, without payload for nil
+func envelope(key string, payload schema) schema {
+	properties := schema{"type": enum(key)}
+	required := []string{"type"}
+	if payload != nil {
+		properties[key] = payload
+		required = append(required, key)
+	}
+	return schema{"type": "object", "properties": properties, "required": required, "additionalProperties": false}
+}
+
+// operations lists the endpoints of the HTTP app
+func operations(s schemas) []operation {
+	lanes := enum(laneNames()...)
+	lines := []schema{envelope("status", s.of(reflect.TypeFor[models.StreamStatus]())), envelope("error", text())}
+	// The lines hold the documents of the buffered response
+	documents := map[string]reflect.Type{
+		db.LaneRecordings: reflect.TypeFor[models.Recording](),
+		db.LaneHumans:     reflect.TypeFor[models.Human](),
+		db.LaneVehicles:   reflect.TypeFor[models.Vehicle](),
+		db.LaneEvents:     reflect.TypeFor[models.Event](),
+	}
+	for _, lane := range laneNames() {
+		lines = append(lines, envelope(lane, s.of(documents[lane])))
+	}
+	selection := []parameter{
+		requiredQuery("timeStamp", "start of the range in unix milliseconds", integer()),
+		requiredQuery("timeStampEnd", "end of the range in unix milliseconds", integer()),
+		query("lanes", "comma separated lanes, all by default", text()),
+		query("gap", "merge gap in milliseconds, at least 100, by default it follows the span", integer()),
+		query("maxPoints", "widens the gap so that each lane has about at most this many segments", integer()),
+	}
+	return []operation{
+		{
+			path:    "/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all",
+			id:      "getTimeline",
+			summary: "Documents of the four lanes overlapping a range",
+			description: "Clients accepting application/x-ndjson get one line per document as it is read, lane " +
+				"after lane, then a status line. A failure ends the stream with an error line.",
+			responses: map[int]map[string]schema{
+				200: {mimeJSON: s.of(reflect.TypeFor[models.TimeLineResponse]()), mimeNDJSON: {"anyOf": lines}},
+			},
+		},
+		{
+			path:    "/site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/coverage",
+			id:      "getCoverage",
+			summary: "Recording coverage and gaps of a range",
+			params:  []parameter{query("minGap", "shortest gap listed in milliseconds, 60000 by default", integer())},
+			responses: map[int]map[string]schema{
+				200: {mimeJSON: s.of(reflect.TypeFor[models.CoverageReport]())},
+			},
+		},
+		{
+			path:    "/site/:siteId/channel/:channelId/stats",
+			id:      "getLaneStats",
+			summary: "Document counts and bounds of every lane",
+			responses: map[int]map[string]schema{
+				200: {mimeJSON: s.of(reflect.TypeFor[[]models.LaneStats]())},
+			},
+		},
+		{
+			path:    "/site/:siteId/channel/:channelId/snap/:timeStamp",
+			id:      "snap",
+			summary: "Document of a lane nearest to a time",
+			params:  []parameter{query("lane", "lane, recordings by default", lanes)},
+			responses: map[int]map[string]schema{
+				200: {mimeJSON: s.of(reflect.TypeFor[models.Segment]())},
+				404: nil,
+			},
+		},
+		{
+			path:    "/api/v1/timeline/site/:siteId/channel/:channelId",
+			id:      "getTimelinePage",
+			summary: "A page of the merged segments of several lanes",
+			params: append(slices.Clone(selection),
+				query("limit", "segments per page, 1000 by default and at most 10000", integer()),
+				query("order", "order by start, asc by default", enum("asc", "desc")),
+				query("cursor", "nextCursor of the previous page", text()),
+			),
+			responses: map[int]map[string]schema{
+				200: {mimeJSON: s.of(reflect.TypeFor[models.TimelinePage]())},
+			},
+		},
+		{
+			path:    "/api/v1/export/site/:siteId/channel/:channelId",
+			id:      "exportTimeline",
+			summary: "Merged segments of several lanes as a spreadsheet",
+			description: "The rows are streamed lane after lane in start order, a failure ends the file with an " +
+				"error row.",
+			params: append(slices.Clone(selection),
+				query("format", "csv by default", enum("csv", "xlsx")),
+				query("tz", "IANA time zone of the times, UTC by default", text()),
+			),
+			responses: map[int]map[string]schema{
+				200: {mimeCSV: text(), mimeXLSX: schema{"type": "string", "format": "binary"}},
+			},
+		},
+		{
+			path:    "/sse/timeline/site/:siteId/channel/:channelId",
+			id:      "streamCommand",
+			summary: "Messages of a websocket command as server-sent events",
+			description: "Each message of the websocket is an event named after its type with the message as " +
+				"data, see the AsyncAPI document. The stream closes with an end event.",
+			params: []parameter{
+				query("commandId", "id stamped on the messages", text()),
+				requiredQuery("domainMin", "start of the range in unix milliseconds", integer()),
+				requiredQuery("domainMax", "end of the range in unix milliseconds", integer()),
+				query("mode", "segments by default", enum(models.ModeSegments, models.ModeDensity, models.ModeCoverage, models.ModeCorrelate)),
+				query("bins", "bins in density mode", integer()),
+				query("minGap", "shortest gap listed in coverage mode", integer()),
+				query("expression", "JSON of the Expression of correlate mode", text()),
+				{name: "Last-Event-ID", in: "header", description: "id of the last event received, resumes after it", schema: text()},
+			},
+			responses: map[int]map[string]schema{
+				200: {mimeSSE: text()},
+				204: nil,
+			},
+		},
+	}
+}
+
+// openAPIPath returns a route path in the syntax of OpenAPI, /site/{siteId}
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if name, ok := strings.CutPrefix(part, ":"); ok {
+			parts[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// errorResponses are the text errors of every operation
+var errorResponses = map[int]string{ //nolint:gochecknoglobals
+	http.StatusBadRequest:          "Invalid parameters",
+	http.StatusUnauthorized:        "Missing or invalid credentials",
+	http.StatusForbidden:           "Site or channel not allowed",
+	http.StatusTooManyRequests:     "Rate limited",
+	http.StatusInternalServerError: "Store failure",
+}
+
+// document returns the path item object of the operation
+func (o operation) document() schema {
+	params := []schema{}
+	for _, part := range strings.Split(o.path, "/") {
+		if name, ok := strings.CutPrefix(part, ":"); ok {
+			params = append(params, schema{"name": name, "in": "path", "required": true, "description": pathParams[name], "schema": integer()})
+		}
+	}
+	for _, p := range o.params {
+		param := schema{"name": p.name, "in": p.in, "description": p.description, "schema": p.schema}
+		if p.required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	responses := schema{}
+	for status, description := range errorResponses {
+		responses[strconv.Itoa(status)] = schema{"description": description, "content": schema{mimeText: schema{"schema": text()}}}
+	}
+	for status, media := range o.responses {
+		response := schema{"description": http.StatusText(status)}
+		if len(media) > 0 {
+			content := schema{}
+			for mediaType, s := range media {
+				content[mediaType] = schema{"schema": s}
+			}
+			response["content"] = content
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	op := schema{"operationId": o.id, "summary": o.summary, "parameters": params, "responses": responses}
+	if o.description != "" {
+		op["description"] = o.description
+	}
+	return schema{"get": op}
+}
+
+// OpenAPI returns the OpenAPI 3 document of the HTTP endpoints, the schemas are generated from the
+// types of the responses
+func OpenAPI(version string) map[string]any {
+	s := schemas{}
+	paths := schema{}
+	for _, o := range operations(s) {
+		paths[openAPIPath(o.path)] = o.document()
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":       "CacheServer",
+			"version":     version,
+			"description": "Timeline of the recordings, events, humans and vehicles of the channels of sites.",
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas":         s,
+			"securitySchemes": securitySchemes(),
+		},
+		"security": security(),
+	}
+}
+
+// securitySchemes are the credentials accepted when authentication is enabled
+func securitySchemes() schema {
+	return schema{
+		"bearer": schema{"type": "http", "scheme": "bearer"},
+		"apiKey": schema{"type": "apiKey", "in": "header", "name": auth.APIKeyHeader},
+		"token":  schema{"type": "apiKey", "in": "query", "name": auth.TokenQueryParam},
+	}
+}
+
+// security accepts any of the schemes, or none when authentication is disabled
+func security() []schema {
+	return []schema{{"bearer": []string{}}, {"apiKey": []string{}}, {"token": []string{}}, {}}
+}
@@ -0,0 +1,88 @@
+package docs
+
+import (
+	"reflect"
+	"strings"
+)
+
+// schemaRef is the prefix of the references to the named schemas of both documents
+const schemaRef = "#/components/schemas/"
+
+// schema is a JSON schema object in the dialect shared by OpenAPI 3.0 and AsyncAPI 2
+type schema = map[string]any
+
+// schemas collects the schemas of the named struct types reached from the documented values
+type schemas map[string]schema
+
+// of returns the schema of the JSON encoding of t. Named structs are described once under their
+// name and referenced, which also ends the recursion of self-referencing types.
+func (s schemas) of(t reflect.Type) schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Int, reflect.Int64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return schema{"type": "integer", "format": "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			s[t.Name()] = nil
+			s[t.Name()] = s.object(t)
+		}
+		return schema{"$ref": schemaRef + t.Name()}
+	}
+	return schema{}
+}
+
+// object returns the schema of a struct, the fields of untagged embedded structs are inlined
+// like encoding/json does and the fields without omitempty are required
+func (s schemas) object(t reflect.Type) schema {
+	properties := schema{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = s.of(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	object := schema{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
@@ -0,0 +1,187 @@
+package docs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUndocumented is returned for responses and messages missing from the documents
+	ErrUndocumented = errors.New("undocumented")
+	// ErrMismatch is returned for values not matching their documented schema
+	ErrMismatch = errors.New("does not match the schema")
+)
+
+// validator checks decoded JSON values against the schemas of a document
+type validator struct {
+	schemas schemas
+}
+
+// ValidateResponse checks a response of the route path, such as /site/:siteId/channel/:channelId/stats,
+// against the OpenAPI document. JSON bodies are checked against the schema of the status and
+// content type, NDJSON bodies line by line, other bodies only need a documented content type.
+func ValidateResponse(path string, status int, contentType string, body []byte) error {
+	s := schemas{}
+	var op *operation
+	for _, o := range operations(s) {
+		if o.path == path {
+			op = &o
+			break
+		}
+	}
+	if op == nil {
+		return fmt.Errorf("%w: path %s", ErrUndocumented, path)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: content type %q of %s", ErrUndocumented, contentType, path)
+	}
+	if _, ok := errorResponses[status]; ok && mediaType == mimeText {
+		return nil
+	}
+	media, ok := op.responses[status]
+	if !ok {
+		return fmt.Errorf("%w: status %d of %s", ErrUndocumented, status, path)
+	}
+	if len(media) == 0 {
+		return nil
+	}
+	expected, ok := media[mediaType]
+	if !ok {
+		return fmt.Errorf("%w: %s response %d of %s", ErrUndocumented, mediaType, status, path)
+	}
+	v := validator{schemas: s}
+	switch mediaType {
+	case mimeJSON:
+		return v.validateJSON(expected, body, path)
+	case mimeNDJSON:
+		for i, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+			if err = v.validateJSON(expected, line, path+" line "+strconv.Itoa(i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateMessage checks a message of the timeline websocket against the messages the AsyncAPI
+// document lists for its type
+func ValidateMessage(data []byte) error {
+	s := schemas{}
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("%w: %w", ErrMismatch, err)
+	}
+	messages := serverMessages(s)
+	i := slices.IndexFunc(messages, func(m message) bool { return m.name == envelope.Type })
+	if i < 0 {
+		return fmt.Errorf("%w: message %q", ErrUndocumented, envelope.Type)
+	}
+	return validator{schemas: s}.validateJSON(messages[i].payload, data, "message "+envelope.Type)
+}
+
+func (v validator) validateJSON(s schema, data []byte, at string) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrMismatch, at, err)
+	}
+	return v.check(s, value, at)
+}
+
+// check checks value against s, supporting the keywords the generated schemas use
+func (v validator) check(s schema, value any, at string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		return v.check(v.schemas[strings.TrimPrefix(ref, schemaRef)], value, at)
+	}
+	if anyOf, ok := s["anyOf"].([]schema); ok {
+		errs := make([]error, 0, len(anyOf))
+		for _, option := range anyOf {
+			err := v.check(option, value, at)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+	mismatch := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s %s", ErrMismatch, at, fmt.Sprintf(format, args...))
+	}
+	switch s["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return mismatch("is %T, not an object", value)
+		}
+		properties, _ := s["properties"].(schema)
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok = object[name]; !ok {
+				return mismatch("misses %s", name)
+			}
+		}
+		for name, field := range object {
+			property, known := properties[name].(schema)
+			if !known {
+				property, known = s["additionalProperties"].(schema)
+			}
+			if !known {
+				return mismatch("has unexpected %s", name)
+			}
+			if err := v.check(property, field, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return mismatch("is %T, not an array", value)
+		}
+		items, _ := s["items"].(schema)
+		for i, item := range array {
+			if err := v.check(items, item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return mismatch("is %T, not a string", value)
+		}
+		if values, ok := s["enum"].([]string); ok && !slices.Contains(values, str) {
+			return mismatch("is %q, not one of %v", str, values)
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return mismatch("is %T, not an integer", value)
+		}
+		if _, err := strconv.ParseInt(number.String(), 10, 64); err != nil {
+			if _, err = strconv.ParseUint(number.String(), 10, 64); err != nil {
+				return mismatch("is %s, not an integer", number)
+			}
+		}
+		if _, unsigned := s["minimum"]; unsigned && strings.HasPrefix(number.String(), "-") {
+			return mismatch("is %s, not unsigned", number)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return mismatch("is %T, not a number", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch("is %T, not a boolean", value)
+		}
+	}
+	return nil
+}
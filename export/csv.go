@@ -0,0 +1,44 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/vtpl1/cacheserver/models"
+)
+
+type csvWriter struct {
+	w   *csv.Writer
+	loc *time.Location
+}
+
+func newCSVWriter(w io.Writer, loc *time.Location) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), loc: loc}
+	if err := c.w.Write(header(loc)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvWriter) WriteSegment(lane string, seg models.Segment) error {
+	millis := duration(seg)
+	return c.w.Write([]string{
+		lane,
+		formatTime(seg.TimeStamp, c.loc),
+		formatTime(seg.TimeStampEnd, c.loc),
+		formatDuration(millis),
+		strconv.FormatFloat(float64(millis)/1000, 'f', 3, 64),
+		strconv.FormatInt(seg.ObjectCount, 10),
+	})
+}
+
+func (c *csvWriter) WriteError(err error) error {
+	return c.w.Write([]string{"error", err.Error()})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
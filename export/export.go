@@ -0,0 +1,80 @@
+// Package export writes merged timeline segments as spreadsheets, one row per segment with
+// readable times in a time zone, as CSV or XLSX
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vtpl1/cacheserver/models"
+
+	// Time zones are resolved without the zoneinfo of the host
+	_ "time/tzdata"
+)
+
+const (
+	// FormatCSV is comma separated values
+	FormatCSV = "csv"
+	// FormatXLSX is an Office Open XML workbook
+	FormatXLSX = "xlsx"
+
+	timeLayout = "2006-01-02 15:04:05.000"
+)
+
+// ErrUnknownFormat is returned for formats other than FormatCSV and FormatXLSX
+var ErrUnknownFormat = errors.New("unknown export format, expected csv or xlsx")
+
+// Writer writes the rows of an export
+type Writer interface {
+	// WriteSegment writes the row of a segment of a lane
+	WriteSegment(lane string, seg models.Segment) error
+	// WriteError writes a last row reporting that the export is incomplete
+	WriteError(err error) error
+	// Close completes the export, it does not close the underlying writer
+	Close() error
+}
+
+// New returns the Writer of format writing to w, with times in loc. The header row is written
+// at once.
+func New(w io.Writer, format string, loc *time.Location) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, loc)
+	case FormatXLSX:
+		return newXLSXWriter(w, loc)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ContentType returns the media type of format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// header returns the column names, the times are in loc
+func header(loc *time.Location) []string {
+	return []string{"Lane", fmt.Sprintf("Start (%s)", loc), fmt.Sprintf("End (%s)", loc), "Duration", "Duration (s)", "Object count"}
+}
+
+// formatTime formats unix milliseconds in loc
+func formatTime(millis uint64, loc *time.Location) string {
+	return time.UnixMilli(int64(millis)).In(loc).Format(timeLayout) //nolint:gosec // unix millis
+}
+
+// duration returns the length of seg in milliseconds
+func duration(seg models.Segment) int64 {
+	if seg.TimeStampEnd < seg.TimeStamp {
+		return 0
+	}
+	return int64(seg.TimeStampEnd - seg.TimeStamp) //nolint:gosec // unix millis
+}
+
+// formatDuration formats milliseconds as hours:minutes:seconds.milliseconds, hours grow past 24
+func formatDuration(millis int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
@@ -0,0 +1,95 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/export"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+var errLost = errors.New("store lost")
+
+func kolkata(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	return loc
+}
+
+func writeExport(t *testing.T, format string, loc *time.Location) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := export.New(&buf, format, loc)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSegment("humans", models.Segment{TimeStamp: 1733931000000, TimeStampEnd: 1733934723500, ObjectCount: 7}))
+	require.NoError(t, w.WriteSegment("events", models.Segment{TimeStamp: 1733931000000, TimeStampEnd: 1733931000000}))
+	require.NoError(t, w.WriteError(errLost))
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	data := writeExport(t, export.FormatCSV, kolkata(t))
+	assert.Equal(t, `Lane,Start (Asia/Kolkata),End (Asia/Kolkata),Duration,Duration (s),Object count
+humans,2024-12-11 21:00:00.000,2024-12-11 22:02:03.500,01:02:03.500,3723.500,7
+events,2024-12-11 21:00:00.000,2024-12-11 21:00:00.000,00:00:00.000,0.000,0
+error,store lost
+`, string(data))
+}
+
+// sheetRow is a row of the sheet of a workbook
+type sheetRow struct {
+	Cells []struct {
+		Ref    string `xml:"r,attr"`
+		Type   string `xml:"t,attr"`
+		Value  string `xml:"v"`
+		Inline string `xml:"is>t"`
+	} `xml:"c"`
+}
+
+func TestXLSX(t *testing.T) {
+	data := writeExport(t, export.FormatXLSX, time.UTC)
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	parts := map[string][]byte{}
+	for _, f := range z.File {
+		r, openErr := f.Open()
+		require.NoError(t, openErr)
+		parts[f.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		_ = r.Close()
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		require.Contains(t, parts, name)
+		require.NoError(t, xml.Unmarshal(parts[name], new(struct{})), "%s is well formed", name)
+	}
+
+	var sheet struct {
+		Rows []sheetRow `xml:"sheetData>row"`
+	}
+	require.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet))
+	require.Len(t, sheet.Rows, 4)
+	assert.Equal(t, "Start (UTC)", sheet.Rows[0].Cells[1].Inline)
+	humans := sheet.Rows[1].Cells
+	require.Len(t, humans, 6)
+	assert.Equal(t, "A2", humans[0].Ref)
+	assert.Equal(t, "humans", humans[0].Inline)
+	assert.Equal(t, "2024-12-11 15:30:00.000", humans[1].Inline)
+	assert.Equal(t, "", humans[4].Type, "durations are numbers")
+	assert.Equal(t, "3723.500", humans[4].Value)
+	assert.Equal(t, "7", humans[5].Value)
+	assert.Equal(t, "store lost", sheet.Rows[3].Cells[1].Inline)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := export.New(io.Discard, "pdf", time.UTC)
+	assert.ErrorIs(t, err, export.ErrUnknownFormat)
+}
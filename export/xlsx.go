@@ -0,0 +1,122 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/vtpl1/cacheserver/models"
+)
+
+// The parts of a workbook with a single sheet of inline strings, the sheet is written last
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Timeline" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// cell is a cell of a row, a number when number is set and text otherwise
+type cell struct {
+	text   string
+	number string
+}
+
+// xlsxWriter streams the sheet rows into the zip entry of the sheet
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	loc   *time.Location
+	rows  int
+}
+
+func newXLSXWriter(w io.Writer, loc *time.Location) (*xlsxWriter, error) {
+	z := zip.NewWriter(w)
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		entry, err := z.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(entry, part.content); err != nil {
+			return nil, err
+		}
+	}
+	entry, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxWriter{zip: z, sheet: bufio.NewWriter(entry), loc: loc}
+	if _, err = x.sheet.WriteString(xlsxSheetStart); err != nil {
+		return nil, err
+	}
+	names := header(loc)
+	cells := make([]cell, len(names))
+	for i, name := range names {
+		cells[i] = cell{text: name}
+	}
+	if err = x.writeRow(cells); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xlsxWriter) writeRow(cells []cell) error {
+	x.rows++
+	_, _ = fmt.Fprintf(x.sheet, `<row r="%d">`, x.rows)
+	for i, c := range cells {
+		ref := string(rune('A'+i)) + strconv.Itoa(x.rows)
+		if c.number != "" {
+			_, _ = fmt.Fprintf(x.sheet, `<c r="%s"><v>%s</v></c>`, ref, c.number)
+			continue
+		}
+		_, _ = fmt.Fprintf(x.sheet, `<c r="%s" t="inlineStr"><is><t>`, ref)
+		if err := xml.EscapeText(x.sheet, []byte(c.text)); err != nil {
+			return err
+		}
+		_, _ = x.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxWriter) WriteSegment(lane string, seg models.Segment) error {
+	millis := duration(seg)
+	return x.writeRow([]cell{
+		{text: lane},
+		{text: formatTime(seg.TimeStamp, x.loc)},
+		{text: formatTime(seg.TimeStampEnd, x.loc)},
+		{text: formatDuration(millis)},
+		{number: strconv.FormatFloat(float64(millis)/1000, 'f', 3, 64)},
+		{number: strconv.FormatInt(seg.ObjectCount, 10)},
+	})
+}
+
+func (x *xlsxWriter) WriteError(err error) error {
+	return x.writeRow([]cell{{text: "error"}, {text: err.Error()}})
+}
+
+func (x *xlsxWriter) Close() error {
+	if _, err := x.sheet.WriteString(xlsxSheetEnd); err != nil {
+		return err
+	}
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/auth"
+	"github.com/vtpl1/cacheserver/timelinepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newGRPCServer returns the gRPC server of the timeline service. It serves TLS when tlsConfig is
+// set and authenticates the calls when authenticator is set, like the HTTP app.
+func newGRPCServer(tlsConfig *tls.Config, authenticator auth.Authenticator) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if authenticator != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(authenticator)),
+			grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(authenticator)),
+		)
+	}
+	server := grpc.NewServer(opts...)
+	timelinepb.RegisterTimelineServiceServer(server, api.NewTimelineServer())
+	return server
+}
+
+// stopGRPCServer lets the running calls finish, cancelling those still running after timeout
+func stopGRPCServer(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		server.Stop()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v3"
+	"github.com/vtpl1/cacheserver/db"
+)
+
+// indexesCommand checks and creates the indexes needed by the timeline queries.
+func indexesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "indexes",
+		Usage: "Inspect the indexes of the timeline collections",
+		Commands: []*cli.Command{
+			{
+				Name:  "check",
+				Usage: "Report missing and unused indexes of every timeline collection",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return runIndexes(ctx, cmd, false)
+				},
+			},
+			{
+				Name:  "apply",
+				Usage: "Create the missing indexes of every timeline collection",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return runIndexes(ctx, cmd, true)
+				},
+			},
+		},
+	}
+}
+
+func runIndexes(ctx context.Context, cmd *cli.Command, apply bool) error {
+	bufferWriter, err := initLogger(cmd.String("logfile"), cmd.String("logLevel"))
+	if err != nil {
+		return err
+	}
+	defer bufferWriter.Close() //nolint:errcheck
+
+	mongoClient, err := db.GetMongoClient(ctx, cmd.String("mongo-connection-string"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to connect to MongoDB")
+		return err
+	}
+	defer mongoClient.Disconnect(ctx) //nolint:errcheck
+
+	reports, err := db.CheckIndexes(ctx, mongoClient, apply)
+	for _, report := range reports {
+		printIndexReport(report)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check indexes")
+		return err
+	}
+	fmt.Printf("Checked %d collections\n", len(reports))
+	return nil
+}
+
+func printIndexReport(report db.IndexReport) {
+	status := "ok"
+	if len(report.Missing) > 0 && len(report.Created) == 0 {
+		status = "missing indexes"
+	}
+	fmt.Printf("%s.%s (%s): %s\n", report.DBName, report.Collection, report.Lane, status)
+	for _, spec := range report.Missing {
+		fmt.Printf("  missing: %s {%s}\n", spec.Name, strings.Join(spec.Keys, ", "))
+	}
+	for _, name := range report.Created {
+		fmt.Printf("  created: %s\n", name)
+	}
+	for _, name := range report.Unused {
+		fmt.Printf("  unused:  %s\n", name)
+	}
+}
@@ -39,6 +39,80 @@ type Vehicle struct {
 	// VehicleCount int    `json:"vehicleCount"`
 }
 
+// Segment represents merged documents of a timeline lane
+type Segment struct {
+	CommandID    string `json:"commandId,omitempty" bson:"commandId,omitempty"`
+	TimeStamp    uint64 `json:"timeStamp" bson:"startTimestamp"`
+	TimeStampEnd uint64 `json:"timeStampEnd" bson:"endTimestamp"`
+	ObjectCount  int64  `json:"objectCount,omitempty" bson:"objectCount,omitempty"`
+}
+
+// Bin represents a fixed width slice of a timeline lane in density mode
+type Bin struct {
+	CommandID    string `json:"commandId,omitempty"`
+	TimeStamp    uint64 `json:"timeStamp"`
+	TimeStampEnd uint64 `json:"timeStampEnd"`
+	ObjectCount  int64  `json:"objectCount"`
+	// Coverage is the fraction of the bin covered by segments, from 0 to 1
+	Coverage float64 `json:"coverage"`
+}
+
+// Gap represents a stretch of time without recordings
+type Gap struct {
+	TimeStamp    uint64 `json:"timeStamp"`
+	TimeStampEnd uint64 `json:"timeStampEnd"`
+	Duration     int64  `json:"duration"`
+}
+
+// CoverageReport describes how much of a range is recorded
+type CoverageReport struct {
+	CommandID    string `json:"commandId,omitempty"`
+	SiteID       int    `json:"siteId"`
+	ChannelID    int    `json:"channelId"`
+	TimeStamp    uint64 `json:"timeStamp"`
+	TimeStampEnd uint64 `json:"timeStampEnd"`
+	// RecordedDuration is the recorded time in milliseconds
+	RecordedDuration int64 `json:"recordedDuration"`
+	// Coverage is the recorded percentage of the range
+	Coverage float64 `json:"coverage"`
+	// Gaps lists the gaps of at least MinGap milliseconds
+	Gaps   []Gap `json:"gaps"`
+	MinGap int64 `json:"minGap"`
+	// Resolution is the merge gap of the recordings in milliseconds, shorter gaps count as recorded
+	Resolution int64 `json:"resolution"`
+}
+
+// LaneStats describes the documents of a lane for a site and channel
+type LaneStats struct {
+	Lane      string `json:"lane"`
+	SiteID    int    `json:"siteId"`
+	ChannelID int    `json:"channelId"`
+	Count     int64  `json:"count"`
+	// First is the start of the earliest document and Last the end of the latest, unset without documents
+	First uint64 `json:"first,omitempty"`
+	Last  uint64 `json:"last,omitempty"`
+}
+
+// LaneSegment is a merged segment of a named lane
+type LaneSegment struct {
+	Lane string `json:"lane"`
+	Segment
+}
+
+// TimelinePage is a page of the merged segments of several lanes, in start order
+type TimelinePage struct {
+	SiteID       int    `json:"siteId"`
+	ChannelID    int    `json:"channelId"`
+	TimeStamp    uint64 `json:"timeStamp"`
+	TimeStampEnd uint64 `json:"timeStampEnd"`
+	// Gap is the merge gap of the segments in milliseconds
+	Gap      int64         `json:"gap"`
+	Order    string        `json:"order"`
+	Segments []LaneSegment `json:"segments"`
+	// NextCursor resumes after the last segment of the page, unset on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
 // Result represents the result of a query
 type Result struct {
 	Recordings []Recording `json:"recording"`
@@ -79,4 +153,78 @@ type Command struct {
 	DisplayMax int    `json:"displayMax"`
 	DomainMin  int    `json:"domainMin"`
 	DomainMax  int    `json:"domainMax"`
+	// Mode is "segments" (the default), "density", "coverage" or "correlate"
+	Mode string `json:"mode,omitempty"`
+	// Bins is the number of bins in density mode
+	Bins int `json:"bins,omitempty"`
+	// MinGap is the shortest gap in milliseconds listed in coverage mode
+	MinGap int64 `json:"minGap,omitempty"`
+	// Expression combines lanes in correlate mode
+	Expression *Expression `json:"expression,omitempty"`
+}
+
+// Expression combines the merged segments of lanes. A leaf names a lane, other nodes apply Op to
+// their Args: "and" and "or" to any number, "not" to one within the range of the command and
+// "touching" keeps the segments of the first overlapping the second.
+type Expression struct {
+	Lane string       `json:"lane,omitempty"`
+	Op   string       `json:"op,omitempty"`
+	Args []Expression `json:"args,omitempty"`
+}
+
+const (
+	// OpAnd keeps the time covered by all arguments
+	OpAnd = "and"
+	// OpOr keeps the time covered by any argument
+	OpOr = "or"
+	// OpNot keeps the time not covered by its argument
+	OpNot = "not"
+	// OpTouching keeps the segments of the first argument overlapping the second
+	OpTouching = "touching"
+)
+
+const (
+	// ModeSegments answers a command with the merged segments of each lane
+	ModeSegments = "segments"
+	// ModeDensity answers a command with fixed width bins of each lane
+	ModeDensity = "density"
+	// ModeCoverage answers a command with the recording coverage report
+	ModeCoverage = "coverage"
+	// ModeCorrelate answers a command with the segments of its expression
+	ModeCorrelate = "correlate"
+)
+
+// CommandStatus is the status message starting and ending the answer of a command
+type CommandStatus struct {
+	// Status is "start" or "done"
+	Status    string  `json:"status"`
+	Command   Command `json:"command"`
+	SiteID    int     `json:"siteId"`
+	ChannelID int     `json:"channelId"`
+	// Counts is the number of segments or bins sent per lane, empty at start
+	Counts map[string]int `json:"counts"`
+}
+
+// QueuedStatus is the status message reporting the queue position of the query of a lane
+type QueuedStatus struct {
+	// Status is "queued"
+	Status    string `json:"status"`
+	CommandID string `json:"commandId"`
+	Lane      string `json:"lane"`
+	Position  int    `json:"position"`
+}
+
+// LaneStatus is the message framing the batches of a lane
+type LaneStatus struct {
+	CommandID string `json:"commandId"`
+	// Status is "start" or "done"
+	Status string `json:"status"`
+}
+
+// StreamStatus is the last line of a streamed timeline
+type StreamStatus struct {
+	// Status is "done"
+	Status string `json:"status"`
+	// Counts is the number of documents streamed per lane
+	Counts map[string]int `json:"counts"`
 }
@@ -0,0 +1,137 @@
+// Package pipeline assembles the aggregation pipelines run on timeline collections from typed stages
+package pipeline
+
+import "go.mongodb.org/mongo-driver/v2/bson"
+
+// Field names of timeline documents
+const (
+	StartField       = "startTimestamp"
+	EndField         = "endTimestamp"
+	ObjectCountField = "objectCount"
+)
+
+// Pipeline is a sequence of aggregation stages, its methods append a stage and return it
+type Pipeline struct {
+	stages bson.A
+}
+
+// New creates an empty pipeline
+func New() *Pipeline {
+	return &Pipeline{}
+}
+
+// Stages returns the stages of the pipeline
+func (p *Pipeline) Stages() bson.A {
+	return p.stages
+}
+
+// Match keeps the documents matching filter
+func (p *Pipeline) Match(filter bson.D) *Pipeline {
+	return p.append(bson.D{{Key: "$match", Value: filter}})
+}
+
+// SortByStart orders the documents by start
+func (p *Pipeline) SortByStart() *Pipeline {
+	return p.append(bson.D{{Key: "$sort", Value: bson.D{{Key: StartField, Value: 1}}}})
+}
+
+// GapMerge merges the documents whose start lies within gap of the end of the document before into
+// segments sorted by start. A segment ends with its last document and sums their object counts.
+func (p *Pipeline) GapMerge(gap int64) *Pipeline {
+	p.SortByStart()
+	p.append(
+		bson.D{{Key: "$addFields", Value: bson.D{
+			{Key: "effectiveEndTimestamp", Value: bson.D{{Key: "$add", Value: bson.A{"$" + EndField, gap}}}},
+		}}},
+		bson.D{{Key: "$setWindowFields", Value: bson.D{
+			{Key: "sortBy", Value: bson.D{{Key: StartField, Value: 1}}},
+			{Key: "output", Value: bson.D{
+				{Key: "prevEffectiveEndTimestamp", Value: bson.D{{Key: "$shift", Value: bson.D{
+					{Key: "output", Value: "$effectiveEndTimestamp"},
+					{Key: "by", Value: -1},
+				}}}},
+			}},
+		}}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "boundary", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$or", Value: bson.A{
+					bson.D{{Key: "$eq", Value: bson.A{"$prevEffectiveEndTimestamp", bson.Null{}}}},
+					bson.D{{Key: "$lt", Value: bson.A{"$prevEffectiveEndTimestamp", "$" + StartField}}},
+				}}},
+				1,
+				0,
+			}}}},
+		}}},
+		bson.D{{Key: "$setWindowFields", Value: bson.D{
+			{Key: "sortBy", Value: bson.D{{Key: StartField, Value: 1}}},
+			{Key: "output", Value: bson.D{
+				{Key: "groupId", Value: bson.D{
+					{Key: "$sum", Value: "$boundary"},
+					{Key: "window", Value: bson.D{{Key: "documents", Value: bson.A{"unbounded", "current"}}}},
+				}},
+			}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$groupId"},
+			{Key: StartField, Value: bson.D{{Key: "$first", Value: "$" + StartField}}},
+			{Key: EndField, Value: bson.D{{Key: "$last", Value: "$" + EndField}}},
+			{Key: ObjectCountField, Value: bson.D{{Key: "$sum", Value: "$" + ObjectCountField}}},
+		}}},
+	)
+	return p.SortByStart()
+}
+
+// Bucket groups the documents by the window of width their start falls in. Each bucket starts
+// with its window, ends with its latest document and sums the object counts; the buckets are
+// sorted by start.
+func (p *Pipeline) Bucket(width int64) *Pipeline {
+	p.append(bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$subtract", Value: bson.A{
+			"$" + StartField,
+			bson.D{{Key: "$mod", Value: bson.A{"$" + StartField, width}}},
+		}}}},
+		{Key: EndField, Value: bson.D{{Key: "$max", Value: "$" + EndField}}},
+		{Key: ObjectCountField, Value: bson.D{{Key: "$sum", Value: "$" + ObjectCountField}}},
+	}}})
+	p.append(bson.D{{Key: "$set", Value: bson.D{{Key: StartField, Value: "$_id"}}}})
+	return p.SortByStart()
+}
+
+// Project keeps fields and drops _id
+func (p *Pipeline) Project(fields ...string) *Pipeline {
+	projection := bson.D{{Key: "_id", Value: 0}}
+	for _, field := range fields {
+		projection = append(projection, bson.E{Key: field, Value: 1})
+	}
+	return p.append(bson.D{{Key: "$project", Value: projection}})
+}
+
+// Limit keeps the first n documents
+func (p *Pipeline) Limit(n int64) *Pipeline {
+	return p.append(bson.D{{Key: "$limit", Value: n}})
+}
+
+func (p *Pipeline) append(stages ...bson.D) *Pipeline {
+	for _, stage := range stages {
+		p.stages = append(p.stages, stage)
+	}
+	return p
+}
+
+// Overlapping matches the documents overlapping [start, end], bounds inclusive
+func Overlapping(start int64, end int64) bson.D {
+	return bson.D{
+		{Key: StartField, Value: bson.D{{Key: "$lte", Value: end}}},
+		{Key: EndField, Value: bson.D{{Key: "$gte", Value: start}}},
+	}
+}
+
+// StartsIn matches the documents starting in [start, end)
+func StartsIn(start int64, end int64) bson.D {
+	return bson.D{{Key: StartField, Value: bson.D{{Key: "$gte", Value: start}, {Key: "$lt", Value: end}}}}
+}
+
+// StartsFrom matches the documents starting at or after start
+func StartsFrom(start int64) bson.D {
+	return bson.D{{Key: StartField, Value: bson.D{{Key: "$gte", Value: start}}}}
+}
@@ -0,0 +1,58 @@
+package pipeline_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/pipeline"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files") //nolint:gochecknoglobals
+
+// assertGolden compares stages, as canonical Extended JSON so that number types count, with
+// testdata/<name>.golden.json
+func assertGolden(t *testing.T, name string, stages bson.A) {
+	t.Helper()
+	got, err := bson.MarshalExtJSONIndent(bson.D{{Key: "pipeline", Value: stages}}, true, false, "", "  ")
+	require.NoError(t, err)
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		require.NoError(t, os.WriteFile(path, got, 0o600))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestGapMerge(t *testing.T) {
+	assertGolden(t, "gap_merge", pipeline.New().
+		Match(pipeline.StartsIn(1000, 2000)).
+		GapMerge(100).
+		Stages())
+}
+
+func TestOverlappingBucket(t *testing.T) {
+	assertGolden(t, "overlapping_bucket", pipeline.New().
+		Match(append(bson.D{{Key: "siteId", Value: 1}, {Key: "channelId", Value: 2}}, pipeline.Overlapping(1000, 2000)...)).
+		Bucket(60000).
+		Limit(500).
+		Stages())
+}
+
+func TestRollup(t *testing.T) {
+	assertGolden(t, "rollup", pipeline.New().
+		Match(pipeline.StartsFrom(1000)).
+		GapMerge(60000).
+		Project(pipeline.StartField, pipeline.EndField, pipeline.ObjectCountField).
+		Stages())
+}
+
+func TestNewIsEmpty(t *testing.T) {
+	assert.Empty(t, pipeline.New().Stages())
+}
@@ -0,0 +1,147 @@
+// Package ratelimit budgets the timeline queries of each client with token buckets charged by query cost
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// idleAfter is how long an unused client bucket is kept at least
+	idleAfter = 10 * time.Minute
+	// sweepEvery is the number of acquisitions between sweeps of idle buckets
+	sweepEvery = 1024
+)
+
+var (
+	// ErrBudgetExceeded is returned when a query costs more than the burst of the client
+	ErrBudgetExceeded = errors.New("query exceeds the budget of the client, narrow the time range")
+	// ErrRateLimited is returned when a query would wait longer than allowed for its tokens
+	ErrRateLimited = errors.New("too many queries, retry later")
+)
+
+// Limit is the token bucket of a client, refilled with Rate tokens per second up to Burst
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Options configures a Limiter
+type Options struct {
+	// Default applies to clients without their own limit
+	Default Limit
+	// Clients maps client keys, such as the subject of an API key, to their limit
+	Clients map[string]Limit
+	// MaxWait is how long a query may be queued for its tokens, 0 rejects at once
+	MaxWait time.Duration
+	// CostPerLane is charged for every lane of a query
+	CostPerLane int
+	// CostPerDay is charged for every lane and started day of the queried span
+	CostPerDay int
+}
+
+// DefaultOptions lets a client query a day of every lane each second and a year at once
+func DefaultOptions() Options {
+	return Options{
+		Default:     Limit{Rate: 8, Burst: 1500},
+		MaxWait:     5 * time.Second,
+		CostPerLane: 1,
+		CostPerDay:  1,
+	}
+}
+
+// LoadClients reads the per client limits of a JSON file mapping client keys to limits
+func LoadClients(path string) (map[string]Limit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var clients map[string]Limit
+	if err = json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Limiter keeps a token bucket per client
+type Limiter struct {
+	opts Options
+
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// New creates a limiter
+func New(opts Options) *Limiter {
+	return &Limiter{opts: opts, buckets: make(map[string]*bucket)}
+}
+
+// Cost returns the tokens charged for querying span on lanes
+func (l *Limiter) Cost(span time.Duration, lanes int) int {
+	days := int((span + 24*time.Hour - 1) / (24 * time.Hour))
+	return lanes * (l.opts.CostPerLane + l.opts.CostPerDay*days)
+}
+
+// Acquire takes cost tokens from the bucket of client, queueing up to MaxWait for them. It
+// returns how long the query waited.
+func (l *Limiter) Acquire(ctx context.Context, client string, cost int) (time.Duration, error) {
+	now := time.Now()
+	reservation := l.bucketOf(client, now).ReserveN(now, cost)
+	if !reservation.OK() {
+		return 0, ErrBudgetExceeded
+	}
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0, nil
+	}
+	if delay > l.opts.MaxWait {
+		reservation.CancelAt(now)
+		return 0, ErrRateLimited
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return time.Since(now), ctx.Err()
+	}
+}
+
+func (l *Limiter) bucketOf(client string, now time.Time) *rate.Limiter {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		for key, b := range l.buckets {
+			// A full bucket is dropped as it would be recreated identical
+			if now.Sub(b.lastUsed) > idleAfter && b.limiter.TokensAt(now) >= float64(b.limiter.Burst()) {
+				delete(l.buckets, key)
+			}
+		}
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		limit, found := l.opts.Clients[client]
+		if !found {
+			limit = l.opts.Default
+		}
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[client] = b
+	}
+	b.lastUsed = now
+	return b.limiter
+}
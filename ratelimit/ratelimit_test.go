@@ -0,0 +1,57 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/ratelimit"
+)
+
+func TestCost(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.DefaultOptions())
+	assert.Equal(t, 4*(1+1), limiter.Cost(time.Minute, 4))
+	assert.Equal(t, 4*(1+1), limiter.Cost(24*time.Hour, 4))
+	assert.Equal(t, 1+366, limiter.Cost(366*24*time.Hour, 1))
+	assert.Equal(t, 4, limiter.Cost(0, 4))
+}
+
+func TestAcquire(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Options{
+		Default: ratelimit.Limit{Rate: 100, Burst: 10},
+		Clients: map[string]ratelimit.Limit{"nvr-service": {Rate: 100, Burst: 100}},
+		MaxWait: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	_, err := limiter.Acquire(ctx, "alice", 11)
+	require.ErrorIs(t, err, ratelimit.ErrBudgetExceeded)
+	_, err = limiter.Acquire(ctx, "nvr-service", 11)
+	require.NoError(t, err)
+
+	waited, err := limiter.Acquire(ctx, "alice", 10)
+	require.NoError(t, err)
+	assert.Zero(t, waited)
+	// The bucket is empty, 2 tokens come within the allowed wait
+	waited, err = limiter.Acquire(ctx, "alice", 2)
+	require.NoError(t, err)
+	assert.Positive(t, waited)
+	// 10 more tokens would take 100ms
+	_, err = limiter.Acquire(ctx, "alice", 10)
+	require.ErrorIs(t, err, ratelimit.ErrRateLimited)
+	// Other clients have their own bucket
+	_, err = limiter.Acquire(ctx, "bob", 10)
+	require.NoError(t, err)
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Options{Default: ratelimit.Limit{Rate: 10, Burst: 10}, MaxWait: time.Second})
+	_, err := limiter.Acquire(context.Background(), "alice", 10)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Acquire(ctx, "alice", 5)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,208 @@
+// Package rollup maintains pre-aggregated timeline collections with merged segments at fixed resolutions
+package rollup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/pipeline"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Resolution is the gap in milliseconds up to which segments of a rollup collection are merged
+type Resolution struct {
+	Name string
+	Gap  int64
+}
+
+// Resolutions returns the maintained resolutions from the finest to the coarsest
+func Resolutions() []Resolution {
+	return []Resolution{
+		{Name: "1m", Gap: int64(time.Minute / time.Millisecond)},
+		{Name: "10m", Gap: int64(10 * time.Minute / time.Millisecond)},
+		{Name: "1h", Gap: int64(time.Hour / time.Millisecond)},
+		{Name: "1d", Gap: int64(24 * time.Hour / time.Millisecond)},
+	}
+}
+
+// CollectionName returns the rollup collection of a raw timeline collection
+func CollectionName(collName string, r Resolution) string {
+	return collName + "_rollup_" + r.Name
+}
+
+// Coarsest returns the coarsest resolution whose segments can still be merged with gap
+func Coarsest(gap int64) (Resolution, bool) {
+	var found Resolution
+	ok := false
+	for _, r := range Resolutions() {
+		if r.Gap <= gap {
+			found = r
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+var (
+	// coveredUntil maps rollup collections to the timestamp (unix millis) up to which they are complete
+	coveredUntil     = make(map[string]int64) //nolint:gochecknoglobals
+	coveredUntilLock sync.RWMutex             //nolint:gochecknoglobals
+)
+
+// Select returns the coarsest rollup collection of collName usable for a query merging with gap
+// and ending at domainMax. It returns false when no rollup is built far enough.
+func Select(collName string, gap int64, domainMax int64) (string, bool) {
+	r, ok := Coarsest(gap)
+	if !ok {
+		return "", false
+	}
+	name := CollectionName(collName, r)
+	coveredUntilLock.RLock()
+	defer coveredUntilLock.RUnlock()
+	if until, built := coveredUntil[name]; built && domainMax <= until {
+		return name, true
+	}
+	return "", false
+}
+
+// Job periodically brings the rollup collections of every per channel lane up to date
+type Job struct {
+	client   *mongo.Client
+	interval time.Duration
+	lookback time.Duration
+}
+
+// NewJob creates a rollup job. Each run recomputes the segments starting within lookback
+// before now, so documents inserted late are still picked up.
+func NewJob(client *mongo.Client, interval time.Duration, lookback time.Duration) *Job {
+	return &Job{client: client, interval: interval, lookback: lookback}
+}
+
+// Run updates the rollups every interval until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.RunOnce(ctx); err != nil {
+			log.Error().Err(err).Msg("Rollup run failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce updates every rollup collection once
+func (j *Job) RunOnce(ctx context.Context) error {
+	start := time.Now()
+	// Data inside the live tail is still being written, rollups only claim what is older
+	until := start.Add(-db.GetClientOptions().LiveTailWindow).UnixMilli()
+	for _, lane := range db.TimelineLanes() {
+		if !lane.PerChannel() {
+			continue
+		}
+		collNames, err := db.LaneCollectionNames(ctx, j.client, lane)
+		if err != nil {
+			return err
+		}
+		for _, collName := range collNames {
+			database := j.client.Database(lane.DBName)
+			for _, r := range Resolutions() {
+				if err = j.update(ctx, database, collName, r); err != nil {
+					return err
+				}
+				coveredUntilLock.Lock()
+				coveredUntil[CollectionName(collName, r)] = until
+				coveredUntilLock.Unlock()
+			}
+		}
+	}
+	log.Info().Int64("time_taken_in_millis", time.Since(start).Milliseconds()).Msg("Rollups updated")
+	return nil
+}
+
+// runField stamps the rollup segments with the update that wrote them, so that the segments no
+// update rewrote are found and deleted
+const runField = "rollupRun"
+
+func (j *Job) update(ctx context.Context, database *mongo.Database, collName string, r Resolution) error {
+	rollupName := CollectionName(collName, r)
+	rollupCollection := database.Collection(rollupName)
+	if _, err := rollupCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "startTimestamp", Value: 1}, {Key: "endTimestamp", Value: 1}}},
+		// $merge matches the rebuilt segments by start
+		{Keys: bson.D{{Key: "startTimestamp", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}); err != nil {
+		return err
+	}
+
+	// Restart from the last segment beginning before the lookback, it may still grow
+	cut := int64(0)
+	var last struct {
+		StartTimestamp int64 `bson:"startTimestamp"`
+	}
+	err := rollupCollection.FindOne(ctx,
+		bson.D{{Key: "startTimestamp", Value: bson.D{{Key: "$lte", Value: time.Now().Add(-j.lookback).UnixMilli()}}}},
+		options.FindOne().SetSort(bson.D{{Key: "startTimestamp", Value: -1}}),
+	).Decode(&last)
+	switch {
+	case err == nil:
+		cut = last.StartTimestamp
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return err
+	}
+	// Readers stay before cut until the rollup is rebuilt from it
+	withhold(rollupName, cut-1)
+
+	// The rebuilt segments replace the ones with the same start before the stale ones are deleted,
+	// so the rollup never misses a segment it held
+	run := time.Now().UnixNano()
+	stages := pipeline.New().
+		Match(pipeline.StartsFrom(cut)).
+		GapMerge(r.Gap).
+		Project(pipeline.StartField, pipeline.EndField, pipeline.ObjectCountField).
+		Stages()
+	stages = append(stages,
+		bson.D{{Key: "$set", Value: bson.D{{Key: runField, Value: run}}}},
+		bson.D{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: rollupName},
+			{Key: "on", Value: pipeline.StartField},
+			// Keeps the _id of the matched segment, the rebuilt one has none
+			{Key: "whenMatched", Value: bson.A{bson.D{{Key: "$set", Value: bson.D{
+				{Key: pipeline.EndField, Value: "$$new." + pipeline.EndField},
+				{Key: pipeline.ObjectCountField, Value: "$$new." + pipeline.ObjectCountField},
+				{Key: runField, Value: "$$new." + runField},
+			}}}}},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}},
+	)
+	cursor, err := database.Collection(collName).Aggregate(ctx, stages, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	if err = cursor.Close(ctx); err != nil {
+		return err
+	}
+	_, err = rollupCollection.DeleteMany(ctx, bson.D{
+		{Key: "startTimestamp", Value: bson.D{{Key: "$gte", Value: cut}}},
+		{Key: runField, Value: bson.D{{Key: "$ne", Value: run}}},
+	})
+	return err
+}
+
+// withhold stops serving the rollup collection name past until
+func withhold(name string, until int64) {
+	coveredUntilLock.Lock()
+	defer coveredUntilLock.Unlock()
+	if covered, built := coveredUntil[name]; built && covered > until {
+		coveredUntil[name] = until
+	}
+}
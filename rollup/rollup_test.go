@@ -0,0 +1,32 @@
+package rollup_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtpl1/cacheserver/rollup"
+)
+
+func TestCoarsest(t *testing.T) {
+	_, ok := rollup.Coarsest(100)
+	assert.False(t, ok, "no rollup is fine enough for a 100ms gap")
+
+	r, ok := rollup.Coarsest(time.Minute.Milliseconds())
+	assert.True(t, ok)
+	assert.Equal(t, "1m", r.Name)
+
+	r, ok = rollup.Coarsest(3 * time.Hour.Milliseconds())
+	assert.True(t, ok)
+	assert.Equal(t, "1h", r.Name)
+
+	r, ok = rollup.Coarsest(30 * 24 * time.Hour.Milliseconds())
+	assert.True(t, ok)
+	assert.Equal(t, "1d", r.Name)
+}
+
+func TestSelectWithoutBuiltRollup(t *testing.T) {
+	_, ok := rollup.Select("pva_HUMAN_1_1", time.Hour.Milliseconds(), 1732271960058)
+	assert.False(t, ok, "rollups are not used before the job has built them")
+	assert.Equal(t, "pva_HUMAN_1_1_rollup_10m", rollup.CollectionName("pva_HUMAN_1_1", rollup.Resolutions()[1]))
+}
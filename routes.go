@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vtpl1/cacheserver/api"
+	"github.com/vtpl1/cacheserver/docs"
+)
+
+// registerRoutes registers the endpoints of the app, the middlewares go first. Event streams,
+// NDJSON timelines and exports end when streamsCtx is done. docs/openapi.json and
+// docs/asyncapi.json document the endpoints.
+func registerRoutes(streamsCtx context.Context, app *fiber.App) {
+	app.Use("/ws/timeline/site/:siteId/channel/:channelId", websocket.New(func(c *websocket.Conn) {
+		ctx1, ok := c.Locals("ctx").(context.Context) // Pass context from Fiber request
+		if !ok {
+			log.Error().Msg("Context does not exists")
+			return
+		}
+		api.TimeLineWSHandler(ctx1, c)
+	}))
+
+	app.Get("sse/timeline/site/:siteId/channel/:channelId", func(c *fiber.Ctx) error {
+		return api.TimeLineSSEHandler(streamsCtx, c)
+	})
+
+	app.Get("site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/timeline/all", func(c *fiber.Ctx) error {
+		return api.TimeLineHandler(streamsCtx, c)
+	})
+	app.Get("site/:siteId/channel/:channelId/:timeStamp/:timeStampEnd/coverage", api.CoverageHandler)
+	app.Get("site/:siteId/channel/:channelId/stats", api.LaneStatsHandler)
+	app.Get("site/:siteId/channel/:channelId/snap/:timeStamp", api.SnapHandler)
+	app.Get("api/v1/timeline/site/:siteId/channel/:channelId", api.TimelineV1Handler)
+	app.Get("api/v1/export/site/:siteId/channel/:channelId", func(c *fiber.Ctx) error {
+		return api.ExportHandler(streamsCtx, c)
+	})
+
+	app.Get("docs", docs.IndexHandler)
+	app.Get("docs/openapi.json", docs.OpenAPIHandler(getVersion()))
+	app.Get("docs/asyncapi.json", docs.AsyncAPIHandler(getVersion()))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtpl1/cacheserver/docs"
+)
+
+// routeParam matches the parameters of a route, :siteId
+var routeParam = regexp.MustCompile(`:(\w+)`) //nolint:gochecknoglobals
+
+// TestRoutesMatchDocs fails when an endpoint is added or removed without its document
+func TestRoutesMatchDocs(t *testing.T) {
+	app := fiber.New()
+	registerRoutes(context.Background(), app)
+
+	var paths, channels []string
+	for _, route := range app.GetRoutes() {
+		path := routeParam.ReplaceAllString(route.Path, "{$1}")
+		switch {
+		case strings.HasPrefix(route.Path, "/docs"), route.Method == fiber.MethodHead:
+		case strings.HasPrefix(route.Path, "/ws/"):
+			if route.Method == fiber.MethodGet {
+				channels = append(channels, path)
+			}
+		case route.Method == fiber.MethodGet:
+			paths = append(paths, path)
+		default:
+			t.Errorf("undocumented %s %s", route.Method, route.Path)
+		}
+	}
+	assert.ElementsMatch(t, slices.Collect(maps.Keys(docs.OpenAPI("test")["paths"].(map[string]any))), paths)
+	assert.ElementsMatch(t, slices.Collect(maps.Keys(docs.AsyncAPI("test")["channels"].(map[string]any))), channels)
+}
@@ -0,0 +1,57 @@
+package segments
+
+import "github.com/vtpl1/cacheserver/models"
+
+// Merger merges documents sorted by start as they arrive, following the rule of the merge
+// aggregation: a document starting within gap of the end of the document before joins its
+// segment, the segment ends with its last document and sums their object counts.
+type Merger struct {
+	gap     int64
+	current models.Segment
+	open    bool
+}
+
+// NewMerger creates a merger joining documents within gap
+func NewMerger(gap int64) *Merger {
+	return &Merger{gap: gap}
+}
+
+// Add merges the next document. It returns the segment closed by doc, if any.
+func (m *Merger) Add(doc models.Segment) (models.Segment, bool) {
+	if !m.open {
+		m.current, m.open = doc, true
+		return models.Segment{}, false
+	}
+	if int64(doc.TimeStamp) > int64(m.current.TimeStampEnd)+m.gap {
+		closed := m.current
+		m.current = doc
+		return closed, true
+	}
+	m.current.TimeStampEnd = doc.TimeStampEnd
+	m.current.ObjectCount += doc.ObjectCount
+	return models.Segment{}, false
+}
+
+// Flush returns the segment still open, if any, and resets the merger
+func (m *Merger) Flush() (models.Segment, bool) {
+	if !m.open {
+		return models.Segment{}, false
+	}
+	m.open = false
+	return m.current, true
+}
+
+// Merge merges documents sorted by start with the rule of Merger
+func Merge(docs []models.Segment, gap int64) []models.Segment {
+	m := NewMerger(gap)
+	var merged []models.Segment
+	for _, doc := range docs {
+		if seg, ok := m.Add(doc); ok {
+			merged = append(merged, seg)
+		}
+	}
+	if seg, ok := m.Flush(); ok {
+		merged = append(merged, seg)
+	}
+	return merged
+}
@@ -0,0 +1,57 @@
+package segments_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/segments"
+)
+
+func TestMerge(t *testing.T) {
+	docs := []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 1000, ObjectCount: 1},
+		{TimeStamp: 1100, TimeStampEnd: 2000, ObjectCount: 2},
+		{TimeStamp: 2100, TimeStampEnd: 2200, ObjectCount: 1},
+		{TimeStamp: 2300, TimeStampEnd: 2400},
+		{TimeStamp: 3000, TimeStampEnd: 4000, ObjectCount: 5},
+	}
+
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 2400, ObjectCount: 4},
+		{TimeStamp: 3000, TimeStampEnd: 4000, ObjectCount: 5},
+	}, segments.Merge(docs, 100), "a start exactly gap after the end joins")
+
+	assert.Equal(t, docs, segments.Merge(docs, 0))
+	assert.Empty(t, segments.Merge(nil, 100))
+}
+
+func TestMergeFollowsLastDocument(t *testing.T) {
+	// Like the aggregation, a segment ends with its last document and the next start is compared
+	// with that end, not with the furthest one
+	docs := []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 5000},
+		{TimeStamp: 100, TimeStampEnd: 200},
+		{TimeStamp: 1000, TimeStampEnd: 1100},
+	}
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 200},
+		{TimeStamp: 1000, TimeStampEnd: 1100},
+	}, segments.Merge(docs, 100))
+}
+
+func TestMergerStreams(t *testing.T) {
+	m := segments.NewMerger(10)
+	_, closed := m.Add(models.Segment{TimeStamp: 0, TimeStampEnd: 10})
+	assert.False(t, closed)
+	_, closed = m.Add(models.Segment{TimeStamp: 15, TimeStampEnd: 20})
+	assert.False(t, closed)
+	seg, closed := m.Add(models.Segment{TimeStamp: 100, TimeStampEnd: 110})
+	assert.True(t, closed)
+	assert.Equal(t, models.Segment{TimeStamp: 0, TimeStampEnd: 20}, seg)
+	seg, ok := m.Flush()
+	assert.True(t, ok)
+	assert.Equal(t, models.Segment{TimeStamp: 100, TimeStampEnd: 110}, seg)
+	_, ok = m.Flush()
+	assert.False(t, ok)
+}
@@ -0,0 +1,191 @@
+// Package segments contains the algorithms working on merged timeline segments
+package segments
+
+import (
+	"math"
+	"slices"
+
+	"github.com/vtpl1/cacheserver/models"
+)
+
+// Stitch merges segments, sorted or not, whose start lies within gap of the end of the segment
+// before. It is used to join segments merged separately, e.g. per bucket, so unlike the document
+// merge it keeps the furthest end instead of the last one.
+func Stitch(segs []models.Segment, gap int64) []models.Segment {
+	if len(segs) == 0 {
+		return segs
+	}
+	sorted := slices.Clone(segs)
+	slices.SortStableFunc(sorted, func(a, b models.Segment) int {
+		switch {
+		case a.TimeStamp < b.TimeStamp:
+			return -1
+		case a.TimeStamp > b.TimeStamp:
+			return 1
+		}
+		return 0
+	})
+	stitched := []models.Segment{sorted[0]}
+	for _, seg := range sorted[1:] {
+		last := &stitched[len(stitched)-1]
+		if int64(seg.TimeStamp) > int64(last.TimeStampEnd)+gap {
+			stitched = append(stitched, seg)
+			continue
+		}
+		last.TimeStampEnd = max(last.TimeStampEnd, seg.TimeStampEnd)
+		last.ObjectCount += seg.ObjectCount
+	}
+	return stitched
+}
+
+// Overlapping returns the segments overlapping [start, end]
+func Overlapping(segs []models.Segment, start uint64, end uint64) []models.Segment {
+	var overlapping []models.Segment
+	for _, seg := range segs {
+		if seg.TimeStampEnd >= start && seg.TimeStamp <= end {
+			overlapping = append(overlapping, seg)
+		}
+	}
+	return overlapping
+}
+
+// Density splits [start, end) into bins of equal width and reports for each the fraction covered
+// by the segments and their object counts, shared among bins in proportion to the overlap.
+// Overlapping segments are expected to be stitched first.
+func Density(segs []models.Segment, start uint64, end uint64, bins int) []models.Bin {
+	if bins <= 0 || end <= start {
+		return nil
+	}
+	width := float64(end-start) / float64(bins)
+	counts := make([]float64, bins)
+	covered := make([]float64, bins)
+	for _, seg := range segs {
+		segStart, segEnd := max(seg.TimeStamp, start), min(seg.TimeStampEnd, end)
+		if segEnd < segStart {
+			continue
+		}
+		first := min(int(float64(segStart-start)/width), bins-1)
+		last := min(int(float64(segEnd-start)/width), bins-1)
+		length := float64(seg.TimeStampEnd - seg.TimeStamp)
+		for i := first; i <= last; i++ {
+			binStart := float64(start) + float64(i)*width
+			overlap := min(float64(segEnd), binStart+width) - max(float64(segStart), binStart)
+			if overlap < 0 {
+				continue
+			}
+			covered[i] += overlap
+			if length == 0 {
+				counts[i] += float64(seg.ObjectCount)
+			} else {
+				counts[i] += float64(seg.ObjectCount) * overlap / length
+			}
+		}
+	}
+	result := make([]models.Bin, bins)
+	for i := range result {
+		binStart := float64(start) + float64(i)*width
+		result[i] = models.Bin{
+			TimeStamp:    uint64(binStart),
+			TimeStampEnd: uint64(binStart + width),
+			ObjectCount:  int64(math.Round(counts[i])),
+			Coverage:     min(covered[i]/width, 1),
+		}
+	}
+	result[bins-1].TimeStampEnd = end
+	return result
+}
+
+// Coverage returns the time of [start, end] covered by stitched segments and the uncovered
+// stretches of at least minGap, including those at both ends of the range
+func Coverage(segs []models.Segment, start uint64, end uint64, minGap int64) (int64, []models.Gap) {
+	var covered int64
+	var gaps []models.Gap
+	addGap := func(from uint64, to uint64) {
+		if to > from && int64(to-from) >= minGap {
+			gaps = append(gaps, models.Gap{TimeStamp: from, TimeStampEnd: to, Duration: int64(to - from)})
+		}
+	}
+	cursor := start
+	for _, seg := range segs {
+		segStart, segEnd := max(seg.TimeStamp, start), min(seg.TimeStampEnd, end)
+		if segEnd < segStart || segEnd <= cursor {
+			continue
+		}
+		segStart = max(segStart, cursor)
+		addGap(cursor, segStart)
+		covered += int64(segEnd - segStart)
+		cursor = segEnd
+	}
+	addGap(cursor, end)
+	return covered, gaps
+}
+
+// Union returns the time covered by any of the segment sets, stitched without gap. Object
+// counts of joined segments are summed.
+func Union(sets ...[]models.Segment) []models.Segment {
+	var all []models.Segment
+	for _, set := range sets {
+		all = append(all, set...)
+	}
+	return Stitch(all, 0)
+}
+
+// Intersect returns the time covered by both segment sets. The segments carry no object count
+// as they no longer match documents of a single lane.
+func Intersect(a []models.Segment, b []models.Segment) []models.Segment {
+	a, b = Stitch(a, 0), Stitch(b, 0)
+	var intersection []models.Segment
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := max(a[i].TimeStamp, b[j].TimeStamp), min(a[i].TimeStampEnd, b[j].TimeStampEnd)
+		if start <= end {
+			intersection = append(intersection, models.Segment{TimeStamp: start, TimeStampEnd: end})
+		}
+		if a[i].TimeStampEnd < b[j].TimeStampEnd {
+			i++
+		} else {
+			j++
+		}
+	}
+	return intersection
+}
+
+// Complement returns the time of [start, end] covered by none of the segments
+func Complement(segs []models.Segment, start uint64, end uint64) []models.Segment {
+	var complement []models.Segment
+	cursor := start
+	for _, seg := range Stitch(segs, 0) {
+		if seg.TimeStampEnd < cursor {
+			continue
+		}
+		if seg.TimeStamp > end {
+			break
+		}
+		if seg.TimeStamp > cursor {
+			complement = append(complement, models.Segment{TimeStamp: cursor, TimeStampEnd: seg.TimeStamp})
+		}
+		cursor = seg.TimeStampEnd
+	}
+	if cursor < end {
+		complement = append(complement, models.Segment{TimeStamp: cursor, TimeStampEnd: end})
+	}
+	return complement
+}
+
+// Touching returns the segments of a overlapping at least one segment of b, bounds inclusive, so
+// that instant events count. Unlike Intersect it keeps whole segments and their object counts.
+func Touching(a []models.Segment, b []models.Segment) []models.Segment {
+	b = Stitch(b, 0)
+	var touching []models.Segment
+	for _, seg := range Stitch(a, 0) {
+		i, _ := slices.BinarySearchFunc(b, seg.TimeStamp, func(other models.Segment, t uint64) int {
+			if other.TimeStampEnd < t {
+				return -1
+			}
+			return 1
+		})
+		if i < len(b) && b[i].TimeStamp <= seg.TimeStampEnd {
+			touching = append(touching, seg)
+		}
+	}
+	return touching
+}
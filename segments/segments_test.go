@@ -0,0 +1,121 @@
+package segments_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/segments"
+)
+
+func TestStitch(t *testing.T) {
+	segs := []models.Segment{
+		{TimeStamp: 5000, TimeStampEnd: 6000, ObjectCount: 2},
+		{TimeStamp: 1000, TimeStampEnd: 4000, ObjectCount: 1},
+		{TimeStamp: 2000, TimeStampEnd: 3000, ObjectCount: 1},
+		{TimeStamp: 9000, TimeStampEnd: 9500, ObjectCount: 4},
+	}
+
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 1000, TimeStampEnd: 6000, ObjectCount: 4},
+		{TimeStamp: 9000, TimeStampEnd: 9500, ObjectCount: 4},
+	}, segments.Stitch(segs, 1000), "a contained segment must not shorten the stitched one")
+
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 1000, TimeStampEnd: 4000, ObjectCount: 2},
+		{TimeStamp: 5000, TimeStampEnd: 6000, ObjectCount: 2},
+		{TimeStamp: 9000, TimeStampEnd: 9500, ObjectCount: 4},
+	}, segments.Stitch(segs, 500))
+
+	assert.Empty(t, segments.Stitch(nil, 1000))
+	assert.Equal(t, uint64(5000), segs[0].TimeStamp, "the input is not modified")
+}
+
+func TestOverlapping(t *testing.T) {
+	segs := []models.Segment{
+		{TimeStamp: 1000, TimeStampEnd: 2000},
+		{TimeStamp: 3000, TimeStampEnd: 4000},
+		{TimeStamp: 5000, TimeStampEnd: 6000},
+	}
+
+	assert.Equal(t, segs[1:2], segments.Overlapping(segs, 2500, 4500))
+	assert.Equal(t, segs, segments.Overlapping(segs, 2000, 5000), "bounds are inclusive")
+	assert.Empty(t, segments.Overlapping(segs, 6001, 7000))
+}
+
+func TestDensity(t *testing.T) {
+	segs := []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 1500, ObjectCount: 3},
+		{TimeStamp: 2500, TimeStampEnd: 3000, ObjectCount: 1},
+		{TimeStamp: 3500, TimeStampEnd: 3500, ObjectCount: 2},
+		{TimeStamp: 9000, TimeStampEnd: 9500, ObjectCount: 5},
+	}
+
+	assert.Equal(t, []models.Bin{
+		{TimeStamp: 1000, TimeStampEnd: 2000, ObjectCount: 1, Coverage: 0.5},
+		{TimeStamp: 2000, TimeStampEnd: 3000, ObjectCount: 1, Coverage: 0.5},
+		{TimeStamp: 3000, TimeStampEnd: 4000, ObjectCount: 2, Coverage: 0},
+	}, segments.Density(segs, 1000, 4000, 3), "counts are shared by overlap, segments outside the range are left out")
+
+	bins := segments.Density(segs, 0, 10000, 3)
+	assert.Len(t, bins, 3)
+	assert.Equal(t, uint64(10000), bins[2].TimeStampEnd, "the last bin ends with the range")
+	assert.InDelta(t, 0.15, bins[2].Coverage, 0.001)
+
+	assert.Empty(t, segments.Density(segs, 1000, 1000, 3))
+	assert.Empty(t, segments.Density(segs, 1000, 4000, 0))
+}
+
+func TestCoverage(t *testing.T) {
+	segs := []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 1500},
+		{TimeStamp: 1200, TimeStampEnd: 2000},
+		{TimeStamp: 2100, TimeStampEnd: 3000},
+		{TimeStamp: 6000, TimeStampEnd: 7000},
+	}
+
+	recorded, gaps := segments.Coverage(segs, 1000, 8000, 500)
+	assert.Equal(t, int64(1000+900+1000), recorded, "overlaps are counted once and clipped to the range")
+	assert.Equal(t, []models.Gap{
+		{TimeStamp: 3000, TimeStampEnd: 6000, Duration: 3000},
+		{TimeStamp: 7000, TimeStampEnd: 8000, Duration: 1000},
+	}, gaps, "gaps shorter than minGap are left out, the tail of the range is a gap")
+
+	recorded, gaps = segments.Coverage(nil, 1000, 2000, 500)
+	assert.Zero(t, recorded)
+	assert.Equal(t, []models.Gap{{TimeStamp: 1000, TimeStampEnd: 2000, Duration: 1000}}, gaps)
+}
+
+func TestSetOperations(t *testing.T) {
+	recordings := []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 1000, ObjectCount: 1},
+		{TimeStamp: 2000, TimeStampEnd: 3000, ObjectCount: 1},
+		{TimeStamp: 5000, TimeStampEnd: 6000, ObjectCount: 1},
+	}
+	humans := []models.Segment{
+		{TimeStamp: 500, TimeStampEnd: 2500, ObjectCount: 4},
+		{TimeStamp: 6000, TimeStampEnd: 6000, ObjectCount: 1},
+	}
+
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 500, TimeStampEnd: 1000},
+		{TimeStamp: 2000, TimeStampEnd: 2500},
+		{TimeStamp: 6000, TimeStampEnd: 6000},
+	}, segments.Intersect(recordings, humans))
+
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 0, TimeStampEnd: 3000, ObjectCount: 6},
+		{TimeStamp: 5000, TimeStampEnd: 6000, ObjectCount: 2},
+	}, segments.Union(recordings, humans), "object counts of joined segments are summed")
+
+	assert.Equal(t, []models.Segment{
+		{TimeStamp: 1000, TimeStampEnd: 2000},
+		{TimeStamp: 3000, TimeStampEnd: 5000},
+		{TimeStamp: 6000, TimeStampEnd: 7000},
+	}, segments.Complement(recordings, 500, 7000))
+	assert.Equal(t, []models.Segment{{TimeStamp: 0, TimeStampEnd: 100}}, segments.Complement(nil, 0, 100))
+
+	assert.Equal(t, recordings, segments.Touching(recordings, humans), "instant segments touch")
+	assert.Equal(t, recordings[:2], segments.Touching(recordings, humans[:1]))
+	assert.Empty(t, segments.Touching(recordings, nil))
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/segments"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrUnknownFixture is returned for fixture files not named after the collection of a lane
+var ErrUnknownFixture = errors.New("fixture does not name a lane collection")
+
+// Memory keeps the documents of the lanes in memory, for tests and demos without MongoDB
+type Memory struct {
+	lock sync.RWMutex
+	docs map[channelKey][]models.Segment
+}
+
+type channelKey struct {
+	lane      string
+	siteID    int
+	channelID int
+}
+
+// fixtureDocument is a document of a fixture, siteId and channelId are set in shared collections
+type fixtureDocument struct {
+	SiteID         int   `bson:"siteId"`
+	ChannelID      int   `bson:"channelId"`
+	StartTimestamp int64 `bson:"startTimestamp"`
+	EndTimestamp   int64 `bson:"endTimestamp"`
+	ObjectCount    int64 `bson:"objectCount"`
+}
+
+// NewMemory creates an empty memory store
+func NewMemory() *Memory {
+	return &Memory{docs: make(map[channelKey][]models.Segment)}
+}
+
+// LoadMemory creates a memory store holding the fixtures of dir, see LoadFile
+func LoadMemory(dir string) (*Memory, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	m := NewMemory()
+	for _, path := range paths {
+		if err = m.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// LoadFile adds the documents of a fixture named <database>.<collection>.json, holding the
+// Extended JSON array of the collection as written by mongoexport --jsonArray
+func (m *Memory) LoadFile(path string) error {
+	dbName, collName, _ := strings.Cut(strings.TrimSuffix(filepath.Base(path), ".json"), ".")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var docs []fixtureDocument
+	if err = bson.UnmarshalExtJSON(data, false, &docs); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, lane := range db.TimelineLanes() {
+		if lane.DBName != dbName {
+			continue
+		}
+		if !lane.PerChannel() && lane.Collection == collName {
+			for _, doc := range docs {
+				m.Add(lane.Name, doc.SiteID, doc.ChannelID, doc.segment())
+			}
+			return nil
+		}
+		var siteID, channelID int
+		if lane.PerChannel() && strings.HasPrefix(collName, lane.CollectionPrefix) {
+			if _, err = fmt.Sscanf(strings.TrimPrefix(collName, lane.CollectionPrefix), "%d_%d", &siteID, &channelID); err != nil {
+				return fmt.Errorf("%w: %s", ErrUnknownFixture, path)
+			}
+			for _, doc := range docs {
+				m.Add(lane.Name, siteID, channelID, doc.segment())
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFixture, path)
+}
+
+func (d fixtureDocument) segment() models.Segment {
+	return models.Segment{
+		TimeStamp:    uint64(d.StartTimestamp), //nolint:gosec // unix millis
+		TimeStampEnd: uint64(d.EndTimestamp),   //nolint:gosec // unix millis
+		ObjectCount:  d.ObjectCount,
+	}
+}
+
+// Add stores documents of a lane
+func (m *Memory) Add(lane string, siteID int, channelID int, docs ...models.Segment) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := channelKey{lane, siteID, channelID}
+	stored := append(m.docs[key], docs...)
+	slices.SortStableFunc(stored, func(a, b models.Segment) int {
+		switch {
+		case a.TimeStamp < b.TimeStamp:
+			return -1
+		case a.TimeStamp > b.TimeStamp:
+			return 1
+		}
+		return 0
+	})
+	m.docs[key] = stored
+}
+
+// Segments implements TimelineStore, merging like the aggregation of the Mongo store
+func (m *Memory) Segments(ctx context.Context, q Query, gap int64) ([]models.Segment, error) {
+	docs, err := m.Documents(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return segments.Merge(docs, gap), nil
+}
+
+// Documents implements TimelineStore
+func (m *Memory) Documents(ctx context.Context, q Query) ([]models.Segment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var docs []models.Segment
+	for _, doc := range m.docs[channelKey{q.Lane.Name, q.SiteID, q.ChannelID}] {
+		if q.matches(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// Scan implements TimelineStore
+func (m *Memory) Scan(ctx context.Context, q Query, fn func(doc models.Segment) error) error {
+	docs, err := m.Documents(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snap implements TimelineStore
+func (m *Memory) Snap(ctx context.Context, lane db.Lane, siteID int, channelID int, t int64) (models.Segment, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Segment{}, false, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	doc, found := nearest(m.docs[channelKey{lane.Name, siteID, channelID}], t)
+	return doc, found, nil
+}
+
+// Stats implements TimelineStore
+func (m *Memory) Stats(ctx context.Context, lane db.Lane, siteID int, channelID int) (models.LaneStats, error) {
+	stats := models.LaneStats{Lane: lane.Name, SiteID: siteID, ChannelID: channelID}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	docs := m.docs[channelKey{lane.Name, siteID, channelID}]
+	stats.Count = int64(len(docs))
+	if len(docs) > 0 {
+		stats.First, stats.Last = docs[0].TimeStamp, docs[len(docs)-1].TimeStampEnd
+	}
+	return stats, nil
+}
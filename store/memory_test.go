@@ -0,0 +1,102 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/segments"
+	"github.com/vtpl1/cacheserver/store"
+)
+
+func lane(t *testing.T, name string) db.Lane {
+	t.Helper()
+	l, ok := db.LaneByName(name)
+	require.True(t, ok)
+	return l
+}
+
+func TestLoadMemory(t *testing.T) {
+	m, err := store.LoadMemory(filepath.Join("..", "testdatasuite"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	stats, err := m.Stats(ctx, lane(t, db.LaneHumans), 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.LaneStats{
+		Lane: db.LaneHumans, SiteID: 1, ChannelID: 1, Count: 72,
+		First: 1732271925859, Last: 1735672233781,
+	}, stats)
+
+	stats, err = m.Stats(ctx, lane(t, db.LaneHumans), 1, 2)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Count, "fixtures only fill their own channel")
+}
+
+func TestLoadFileUnknownCollection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pvaDB.pva_UNKNOWN_1_1.json")
+	require.NoError(t, os.WriteFile(path, []byte("[]"), 0o600))
+	err := store.NewMemory().LoadFile(path)
+	assert.True(t, errors.Is(err, store.ErrUnknownFixture))
+}
+
+func TestMemoryQueries(t *testing.T) {
+	m := store.NewMemory()
+	docs := []models.Segment{
+		{TimeStamp: 1000, TimeStampEnd: 2000, ObjectCount: 1},
+		{TimeStamp: 2050, TimeStampEnd: 3000, ObjectCount: 2},
+		{TimeStamp: 5000, TimeStampEnd: 9000, ObjectCount: 3},
+	}
+	m.Add(db.LaneRecordings, 1, 2, docs[2], docs[0], docs[1])
+	m.Add(db.LaneEvents, 1, 3, docs[0])
+	recordings := lane(t, db.LaneRecordings)
+	ctx := context.Background()
+
+	all, err := m.Documents(ctx, store.Query{Lane: recordings, SiteID: 1, ChannelID: 2})
+	require.NoError(t, err)
+	assert.Equal(t, docs, all, "documents are sorted by start")
+
+	overlapping, err := m.Documents(ctx, store.Query{Lane: recordings, SiteID: 1, ChannelID: 2, StartMax: 6000, EndMin: 2500})
+	require.NoError(t, err)
+	assert.Equal(t, docs[1:], overlapping)
+
+	starting, err := m.Documents(ctx, store.Query{Lane: recordings, SiteID: 1, ChannelID: 2, StartMin: 1000, StartMax: 2050})
+	require.NoError(t, err)
+	assert.Equal(t, docs[:1], starting, "StartMax is exclusive")
+
+	var scanned []models.Segment
+	errStop := errors.New("stop")
+	err = m.Scan(ctx, store.Query{Lane: recordings, SiteID: 1, ChannelID: 2}, func(doc models.Segment) error {
+		scanned = append(scanned, doc)
+		if len(scanned) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errStop)
+	assert.Equal(t, docs[:2], scanned, "scanning stops at the first error")
+
+	merged, err := m.Segments(ctx, store.Query{Lane: recordings, SiteID: 1, ChannelID: 2}, 100)
+	require.NoError(t, err)
+	assert.Equal(t, segments.Merge(docs, 100), merged)
+
+	doc, found, err := m.Snap(ctx, recordings, 1, 2, 4000)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, docs[1], doc, "the nearest end wins")
+	doc, _, _ = m.Snap(ctx, recordings, 1, 2, 8000)
+	assert.Equal(t, docs[2], doc, "a document overlapping the time wins")
+	_, found, _ = m.Snap(ctx, recordings, 9, 9, 8000)
+	assert.False(t, found)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = m.Documents(canceled, store.Query{Lane: recordings, SiteID: 1, ChannelID: 2})
+	assert.ErrorIs(t, err, context.Canceled)
+}
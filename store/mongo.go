@@ -0,0 +1,246 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+	"github.com/vtpl1/cacheserver/pipeline"
+	"github.com/vtpl1/cacheserver/rollup"
+	"github.com/vtpl1/cacheserver/segments"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MongoOptions configures a Mongo store
+type MongoOptions struct {
+	// ServerMergeLanes names the lanes merged in the server from a sorted find instead of the
+	// $setWindowFields aggregation, which needs MongoDB 5.0
+	ServerMergeLanes []string
+}
+
+// Mongo reads the lanes from the default MongoDB client, following the read options installed
+// in package db
+type Mongo struct {
+	serverMerge map[string]bool
+}
+
+// NewMongo creates a Mongo store
+func NewMongo(opts MongoOptions) *Mongo {
+	m := &Mongo{serverMerge: make(map[string]bool, len(opts.ServerMergeLanes))}
+	for _, name := range opts.ServerMergeLanes {
+		m.serverMerge[name] = true
+	}
+	return m
+}
+
+// Segments implements TimelineStore
+func (m *Mongo) Segments(ctx context.Context, q Query, gap int64) ([]models.Segment, error) {
+	collName := q.Lane.CollectionName(q.SiteID, q.ChannelID)
+	if q.Lane.PerChannel() {
+		if rollupName, ok := rollup.Select(collName, gap, q.reach()); ok {
+			collName = rollupName
+		}
+	}
+	collection, err := m.collection(q, collName)
+	if err != nil {
+		return nil, err
+	}
+	if m.serverMerge[q.Lane.Name] {
+		return findSegments(ctx, collection, filterOf(q), gap)
+	}
+	return aggregateSegments(ctx, collection, filterOf(q), gap)
+}
+
+// Documents implements TimelineStore
+func (m *Mongo) Documents(ctx context.Context, q Query) ([]models.Segment, error) {
+	collection, err := m.collection(q, q.Lane.CollectionName(q.SiteID, q.ChannelID))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, filterOf(q), options.Find().SetSort(bson.D{{Key: pipeline.StartField, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx) //nolint:errcheck
+
+	var docs []models.Segment
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// Scan implements TimelineStore
+func (m *Mongo) Scan(ctx context.Context, q Query, fn func(doc models.Segment) error) error {
+	collection, err := m.collection(q, q.Lane.CollectionName(q.SiteID, q.ChannelID))
+	if err != nil {
+		return err
+	}
+	cursor, err := collection.Find(ctx, filterOf(q), options.Find().SetSort(bson.D{{Key: pipeline.StartField, Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx) //nolint:errcheck
+
+	for cursor.Next(ctx) {
+		var doc models.Segment
+		if err = cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Snap implements TimelineStore
+func (m *Mongo) Snap(ctx context.Context, lane db.Lane, siteID int, channelID int, t int64) (models.Segment, bool, error) {
+	q := Query{Lane: lane, SiteID: siteID, ChannelID: channelID, Reach: t}
+	collection, err := m.collection(q, lane.CollectionName(siteID, channelID))
+	if err != nil {
+		return models.Segment{}, false, err
+	}
+	// The nearest document is the last starting at or before t or the first starting after it
+	var candidates []models.Segment
+	for _, side := range []struct {
+		query Query
+		order int
+	}{
+		{Query{Lane: lane, SiteID: siteID, ChannelID: channelID, StartMax: t + 1}, -1},
+		{Query{Lane: lane, SiteID: siteID, ChannelID: channelID, StartMin: t + 1}, 1},
+	} {
+		doc, found, findErr := findOne(ctx, collection, filterOf(side.query), side.order)
+		if findErr != nil {
+			return models.Segment{}, false, findErr
+		}
+		if found {
+			candidates = append(candidates, doc)
+		}
+	}
+	doc, found := nearest(candidates, t)
+	return doc, found, nil
+}
+
+// Stats implements TimelineStore
+func (m *Mongo) Stats(ctx context.Context, lane db.Lane, siteID int, channelID int) (models.LaneStats, error) {
+	stats := models.LaneStats{Lane: lane.Name, SiteID: siteID, ChannelID: channelID}
+	q := Query{Lane: lane, SiteID: siteID, ChannelID: channelID}
+	collection, err := m.collection(q, lane.CollectionName(siteID, channelID))
+	if err != nil {
+		return stats, err
+	}
+	filter := filterOf(q)
+	if stats.Count, err = collection.CountDocuments(ctx, filter); err != nil || stats.Count == 0 {
+		return stats, err
+	}
+	first, _, err := findOne(ctx, collection, filter, 1)
+	if err != nil {
+		return stats, err
+	}
+	last, _, err := findOne(ctx, collection, filter, -1)
+	if err != nil {
+		return stats, err
+	}
+	stats.First, stats.Last = first.TimeStamp, last.TimeStampEnd
+	return stats, nil
+}
+
+// collection returns the collection collName of the lane of q, read from the primary when q
+// reaches the live tail
+func (m *Mongo) collection(q Query, collName string) (*mongo.Collection, error) {
+	client, err := db.GetDefaultMongoClient()
+	if err != nil {
+		return nil, err
+	}
+	live := q.reach() == 0 || db.GetClientOptions().IsLiveTail(q.reach())
+	return db.LaneCollection(client, q.Lane.Name, q.Lane.DBName, collName, live), nil
+}
+
+// filterOf returns the find filter of q
+func filterOf(q Query) bson.D {
+	var filter bson.D
+	if !q.Lane.PerChannel() {
+		filter = bson.D{{Key: "siteId", Value: q.SiteID}, {Key: "channelId", Value: q.ChannelID}}
+	}
+	var start bson.D
+	if q.StartMin != 0 {
+		start = append(start, bson.E{Key: "$gte", Value: q.StartMin})
+	}
+	if q.StartMax != 0 {
+		start = append(start, bson.E{Key: "$lt", Value: q.StartMax})
+	}
+	if start != nil {
+		filter = append(filter, bson.E{Key: pipeline.StartField, Value: start})
+	}
+	if q.EndMin != 0 {
+		filter = append(filter, bson.E{Key: pipeline.EndField, Value: bson.D{{Key: "$gte", Value: q.EndMin}}})
+	}
+	if filter == nil {
+		return bson.D{}
+	}
+	return filter
+}
+
+// findOne returns the first document matching filter in start order, or in reverse start order
+// when order is -1
+func findOne(ctx context.Context, collection *mongo.Collection, filter bson.D, order int) (models.Segment, bool, error) {
+	var doc models.Segment
+	err := collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: pipeline.StartField, Value: order}})).Decode(&doc)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return doc, false, nil
+	case err != nil:
+		return doc, false, err
+	}
+	return doc, true, nil
+}
+
+func aggregateSegments(ctx context.Context, collection *mongo.Collection, match bson.D, gap int64) ([]models.Segment, error) {
+	stages := pipeline.New().Match(match).GapMerge(gap).Stages()
+	cursor, err := collection.Aggregate(ctx, stages, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx) //nolint:errcheck
+
+	var segs []models.Segment
+	if err = cursor.All(ctx, &segs); err != nil {
+		return nil, err
+	}
+	return segs, nil
+}
+
+// findSegments reads the documents matching match sorted by start and merges them with gap as
+// they arrive, giving the segments of aggregateSegments without $setWindowFields
+func findSegments(ctx context.Context, collection *mongo.Collection, match bson.D, gap int64) ([]models.Segment, error) {
+	cursor, err := collection.Find(ctx, match, options.Find().
+		SetSort(bson.D{{Key: pipeline.StartField, Value: 1}}).
+		SetProjection(bson.D{{Key: "_id", Value: 0}, {Key: pipeline.StartField, Value: 1}, {Key: pipeline.EndField, Value: 1}, {Key: pipeline.ObjectCountField, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx) //nolint:errcheck
+
+	merger := segments.NewMerger(gap)
+	var segs []models.Segment
+	for cursor.Next(ctx) {
+		var doc models.Segment
+		if err = cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if seg, ok := merger.Add(doc); ok {
+			segs = append(segs, seg)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+	if seg, ok := merger.Flush(); ok {
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
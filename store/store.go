@@ -0,0 +1,76 @@
+// Package store reads the documents of timeline lanes, from MongoDB or from memory
+package store
+
+import (
+	"context"
+
+	"github.com/vtpl1/cacheserver/db"
+	"github.com/vtpl1/cacheserver/models"
+)
+
+// Query selects the documents of a lane for a site and channel starting in [StartMin, StartMax)
+// and ending at or after EndMin, zero bounds are open
+type Query struct {
+	Lane      db.Lane
+	SiteID    int
+	ChannelID int
+	StartMin  int64
+	StartMax  int64
+	EndMin    int64
+	// Reach is the latest time the documents can reach, it selects rollups and the read
+	// preference. StartMax is used when it is zero.
+	Reach int64
+}
+
+// TimelineStore reads the documents of timeline lanes
+type TimelineStore interface {
+	// Segments returns the documents of q merged with gap, sorted by start. Stores may serve
+	// coarse gaps from rollups.
+	Segments(ctx context.Context, q Query, gap int64) ([]models.Segment, error)
+	// Documents returns the documents of q sorted by start
+	Documents(ctx context.Context, q Query) ([]models.Segment, error)
+	// Scan calls fn with each document of q sorted by start as it is read, holding only the
+	// current one. It stops at the first error of fn and returns it.
+	Scan(ctx context.Context, q Query, fn func(doc models.Segment) error) error
+	// Snap returns the document of a lane nearest to t, one overlapping t first. It reports
+	// false when the lane has no documents.
+	Snap(ctx context.Context, lane db.Lane, siteID int, channelID int, t int64) (models.Segment, bool, error)
+	// Stats describes the documents of a lane
+	Stats(ctx context.Context, lane db.Lane, siteID int, channelID int) (models.LaneStats, error)
+}
+
+// reach returns the latest time the documents of q can reach
+func (q Query) reach() int64 {
+	if q.Reach != 0 {
+		return q.Reach
+	}
+	return q.StartMax
+}
+
+// matches reports whether a document of the lane, site and channel of q falls in its bounds
+func (q Query) matches(doc models.Segment) bool {
+	start, end := int64(doc.TimeStamp), int64(doc.TimeStampEnd) //nolint:gosec // unix millis
+	return (q.StartMin == 0 || start >= q.StartMin) &&
+		(q.StartMax == 0 || start < q.StartMax) &&
+		(q.EndMin == 0 || end >= q.EndMin)
+}
+
+// nearest returns the document of docs, sorted by start, nearest to t, one overlapping t first
+func nearest(docs []models.Segment, t int64) (models.Segment, bool) {
+	var best models.Segment
+	bestDistance := int64(-1)
+	for _, doc := range docs {
+		start, end := int64(doc.TimeStamp), int64(doc.TimeStampEnd) //nolint:gosec // unix millis
+		var distance int64
+		switch {
+		case t < start:
+			distance = start - t
+		case t > end:
+			distance = t - end
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = doc, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
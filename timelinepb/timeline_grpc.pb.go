@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: timeline/v1/timeline.proto
+
+package timelinepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TimelineService_GetTimeline_FullMethodName    = "/timeline.v1.TimelineService/GetTimeline"
+	TimelineService_StreamTimeline_FullMethodName = "/timeline.v1.TimelineService/StreamTimeline"
+	TimelineService_Snap_FullMethodName           = "/timeline.v1.TimelineService/Snap"
+)
+
+// TimelineServiceClient is the client API for TimelineService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TimelineService serves the timeline lanes of the sites and channels to backend services
+type TimelineServiceClient interface {
+	// GetTimeline returns the documents of the lanes overlapping a range, like timeline/all
+	GetTimeline(ctx context.Context, in *GetTimelineRequest, opts ...grpc.CallOption) (*GetTimelineResponse, error)
+	// StreamTimeline streams the merged segments of the lanes over a range in batches, like the
+	// segments command of the websocket, and ends with the counts of the lanes
+	StreamTimeline(ctx context.Context, in *StreamTimelineRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamTimelineResponse], error)
+	// Snap returns the document of a lane nearest to a time, NOT_FOUND when the lane is empty
+	Snap(ctx context.Context, in *SnapRequest, opts ...grpc.CallOption) (*SnapResponse, error)
+}
+
+type timelineServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTimelineServiceClient(cc grpc.ClientConnInterface) TimelineServiceClient {
+	return &timelineServiceClient{cc}
+}
+
+func (c *timelineServiceClient) GetTimeline(ctx context.Context, in *GetTimelineRequest, opts ...grpc.CallOption) (*GetTimelineResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTimelineResponse)
+	err := c.cc.Invoke(ctx, TimelineService_GetTimeline_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timelineServiceClient) StreamTimeline(ctx context.Context, in *StreamTimelineRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamTimelineResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TimelineService_ServiceDesc.Streams[0], TimelineService_StreamTimeline_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamTimelineRequest, StreamTimelineResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TimelineService_StreamTimelineClient = grpc.ServerStreamingClient[StreamTimelineResponse]
+
+func (c *timelineServiceClient) Snap(ctx context.Context, in *SnapRequest, opts ...grpc.CallOption) (*SnapResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SnapResponse)
+	err := c.cc.Invoke(ctx, TimelineService_Snap_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TimelineServiceServer is the server API for TimelineService service.
+// All implementations must embed UnimplementedTimelineServiceServer
+// for forward compatibility.
+//
+// TimelineService serves the timeline lanes of the sites and channels to backend services
+type TimelineServiceServer interface {
+	// GetTimeline returns the documents of the lanes overlapping a range, like timeline/all
+	GetTimeline(context.Context, *GetTimelineRequest) (*GetTimelineResponse, error)
+	// StreamTimeline streams the merged segments of the lanes over a range in batches, like the
+	// segments command of the websocket, and ends with the counts of the lanes
+	StreamTimeline(*StreamTimelineRequest, grpc.ServerStreamingServer[StreamTimelineResponse]) error
+	// Snap returns the document of a lane nearest to a time, NOT_FOUND when the lane is empty
+	Snap(context.Context, *SnapRequest) (*SnapResponse, error)
+	mustEmbedUnimplementedTimelineServiceServer()
+}
+
+// UnimplementedTimelineServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTimelineServiceServer struct{}
+
+func (UnimplementedTimelineServiceServer) GetTimeline(context.Context, *GetTimelineRequest) (*GetTimelineResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTimeline not implemented")
+}
+func (UnimplementedTimelineServiceServer) StreamTimeline(*StreamTimelineRequest, grpc.ServerStreamingServer[StreamTimelineResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTimeline not implemented")
+}
+func (UnimplementedTimelineServiceServer) Snap(context.Context, *SnapRequest) (*SnapResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Snap not implemented")
+}
+func (UnimplementedTimelineServiceServer) mustEmbedUnimplementedTimelineServiceServer() {}
+func (UnimplementedTimelineServiceServer) testEmbeddedByValue()                         {}
+
+// UnsafeTimelineServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TimelineServiceServer will
+// result in compilation errors.
+type UnsafeTimelineServiceServer interface {
+	mustEmbedUnimplementedTimelineServiceServer()
+}
+
+func RegisterTimelineServiceServer(s grpc.ServiceRegistrar, srv TimelineServiceServer) {
+	// If the following call pancis, it indicates UnimplementedTimelineServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TimelineService_ServiceDesc, srv)
+}
+
+func _TimelineService_GetTimeline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTimelineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimelineServiceServer).GetTimeline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimelineService_GetTimeline_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimelineServiceServer).GetTimeline(ctx, req.(*GetTimelineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimelineService_StreamTimeline_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamTimelineRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TimelineServiceServer).StreamTimeline(m, &grpc.GenericServerStream[StreamTimelineRequest, StreamTimelineResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TimelineService_StreamTimelineServer = grpc.ServerStreamingServer[StreamTimelineResponse]
+
+func _TimelineService_Snap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SnapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimelineServiceServer).Snap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimelineService_Snap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimelineServiceServer).Snap(ctx, req.(*SnapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TimelineService_ServiceDesc is the grpc.ServiceDesc for TimelineService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TimelineService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "timeline.v1.TimelineService",
+	HandlerType: (*TimelineServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTimeline",
+			Handler:    _TimelineService_GetTimeline_Handler,
+		},
+		{
+			MethodName: "Snap",
+			Handler:    _TimelineService_Snap_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTimeline",
+			Handler:       _TimelineService_StreamTimeline_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "timeline/v1/timeline.proto",
+}
@@ -0,0 +1,124 @@
+// Package tlsutil builds the TLS configuration of the server with certificates reloaded when their files change
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoClientCA is returned when the client CA file holds no PEM certificate
+var ErrNoClientCA = errors.New("no certificate found in the client CA file")
+
+// CertReloader serves a certificate and key pair, loading it again when either file changes
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// NewCertReloader loads the pair a first time
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the pair again when a file changed since the last load and reports whether it
+// did. The current certificate is kept when the files can not be loaded, such as while they are
+// being replaced.
+func (r *CertReloader) Reload() (bool, error) {
+	modTimes, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+	r.lock.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.lock.Lock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	r.lock.Unlock()
+	return true, nil
+}
+
+// Watch reloads the pair every interval until ctx is done
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.Reload()
+		if err != nil {
+			log.Error().Err(err).Str("cert", r.certFile).Msg("Failed to reload the TLS certificate")
+			continue
+		}
+		if reloaded {
+			log.Info().Str("cert", r.certFile).Msg("TLS certificate reloaded")
+		}
+	}
+}
+
+func (r *CertReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// ServerConfig returns a TLS configuration serving the certificates of r. When clientCAFile is
+// set, clients must present a certificate signed by one of its CAs.
+func ServerConfig(r *CertReloader, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrNoClientCA
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}